@@ -0,0 +1,131 @@
+package ansi
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// escapeSequence matches CSI sequences (ESC[<params><final>) even if
+// they are cut off, OSC sequences (ESC]...BEL) and all other escapes
+var escapeSequence = regexp.MustCompile("\x1b(?:\\[([0-9;?]*)([A-Za-z])?|\\][^\x07\x1b]*(?:\x07|\x1b\\\\)?|[()][0-9A-Za-z]|.?)")
+
+// State holds the colours and text styles active at the end of a line
+type State struct {
+	Foreground int
+	Background int
+	Bold       bool
+	Italic     bool
+	Underline  bool
+}
+
+func (a State) classes() []string {
+	classes := []string{}
+	if a.Foreground > 0 {
+		classes = append(classes, "ansi-fg-"+strconv.Itoa(a.Foreground))
+	}
+	if a.Background > 0 {
+		classes = append(classes, "ansi-bg-"+strconv.Itoa(a.Background))
+	}
+	if a.Bold {
+		classes = append(classes, "ansi-bold")
+	}
+	if a.Italic {
+		classes = append(classes, "ansi-italic")
+	}
+	if a.Underline {
+		classes = append(classes, "ansi-underline")
+	}
+	return classes
+}
+
+// apply changes the state according to the parameters of a SGR sequence
+// (`ESC[<params>m`). Unsupported parameters are ignored.
+func (a *State) apply(params string) {
+	if params == "" {
+		params = "0"
+	}
+
+	for _, p := range strings.Split(params, ";") {
+		code, err := strconv.Atoi(p)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case code == 0:
+			*a = State{}
+		case code == 1:
+			a.Bold = true
+		case code == 3:
+			a.Italic = true
+		case code == 4:
+			a.Underline = true
+		case code == 22:
+			a.Bold = false
+		case code == 23:
+			a.Italic = false
+		case code == 24:
+			a.Underline = false
+		case code >= 30 && code <= 37, code >= 90 && code <= 97:
+			a.Foreground = code
+		case code == 39:
+			a.Foreground = 0
+		case code >= 40 && code <= 47, code >= 100 && code <= 107:
+			a.Background = code
+		case code == 49:
+			a.Background = 0
+		}
+	}
+}
+
+// ToHTML converts a line containing ANSI escape sequences into escaped
+// HTML using spans with `ansi-*` classes for the colours and text styles.
+// The state is passed in and returned to support styles spanning lines.
+func ToHTML(line string, state State) (string, State) {
+	buf := bytes.NewBuffer([]byte{})
+	open := false
+
+	writeText := func(text string) {
+		if text == "" {
+			return
+		}
+		if classes := state.classes(); len(classes) > 0 && !open {
+			buf.WriteString(`<span class="` + strings.Join(classes, " ") + `">`)
+			open = true
+		}
+		buf.WriteString(html.EscapeString(text))
+	}
+
+	pos := 0
+	for _, m := range escapeSequence.FindAllStringSubmatchIndex(line, -1) {
+		writeText(line[pos:m[0]])
+		pos = m[1]
+
+		// Only SGR sequences are rendered, cursor movements and similar
+		// sequences make no sense in a static log and are dropped
+		if m[4] < 0 || line[m[4]:m[5]] != "m" {
+			continue
+		}
+
+		if open {
+			buf.WriteString("</span>")
+			open = false
+		}
+		state.apply(line[m[2]:m[3]])
+	}
+	writeText(line[pos:])
+
+	if open {
+		buf.WriteString("</span>")
+	}
+
+	return buf.String(), state
+}
+
+// Strip removes all ANSI escape sequences from the text
+func Strip(text string) string {
+	return escapeSequence.ReplaceAllString(text, "")
+}
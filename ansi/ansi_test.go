@@ -0,0 +1,117 @@
+package ansi
+
+import (
+	"testing"
+)
+
+func TestToHTML(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		line string
+		html string
+	}{
+		{
+			name: "plain text",
+			line: "go build ./...",
+			html: "go build ./...",
+		},
+		{
+			name: "HTML is escaped",
+			line: `<script>alert("x")</script> & more`,
+			html: "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; more",
+		},
+		{
+			name: "HTML inside colours is escaped",
+			line: "\x1b[31m<b>failed</b>\x1b[0m",
+			html: `<span class="ansi-fg-31">&lt;b&gt;failed&lt;/b&gt;</span>`,
+		},
+		{
+			name: "span closed on reset",
+			line: "\x1b[1;32mok\x1b[0m done",
+			html: `<span class="ansi-fg-32 ansi-bold">ok</span> done`,
+		},
+		{
+			name: "span closed on empty reset",
+			line: "\x1b[4mlink\x1b[m text",
+			html: `<span class="ansi-underline">link</span> text`,
+		},
+		{
+			name: "span closed at end of input",
+			line: "\x1b[33mwarning",
+			html: `<span class="ansi-fg-33">warning</span>`,
+		},
+		{
+			name: "span reopened on change",
+			line: "\x1b[31mred\x1b[44mon blue",
+			html: `<span class="ansi-fg-31">red</span><span class="ansi-fg-31 ansi-bg-44">on blue</span>`,
+		},
+		{
+			name: "no empty spans",
+			line: "\x1b[31m\x1b[0mtext\x1b[32m",
+			html: "text",
+		},
+		{
+			name: "unknown parameters are ignored",
+			line: "\x1b[38;5;82mtext",
+			html: "text",
+		},
+		{
+			name: "cursor movements are dropped",
+			line: "\x1b[2K\x1b[1Gprogress\x1b[?25l",
+			html: "progress",
+		},
+		{
+			name: "OSC sequences are dropped",
+			line: "\x1b]0;window title\x07text",
+			html: "text",
+		},
+		{
+			name: "charset selection is dropped",
+			line: "\x1b(Btext",
+			html: "text",
+		},
+		{
+			name: "partial sequence at end is dropped",
+			line: "text\x1b[31",
+			html: "text",
+		},
+		{
+			name: "lone escape is dropped",
+			line: "text\x1b",
+			html: "text",
+		},
+		{
+			name: "partial sequence in text is dropped",
+			line: "a\x1b[3 b",
+			html: "a b",
+		},
+	} {
+		if html, _ := ToHTML(c.line, State{}); html != c.html {
+			t.Errorf("%s: ToHTML(%q) = %q, expected %q", c.name, c.line, html, c.html)
+		}
+	}
+}
+
+func TestToHTMLState(t *testing.T) {
+	html, state := ToHTML("\x1b[1;31merror: build", State{})
+	if html != `<span class="ansi-fg-31 ansi-bold">error: build</span>` {
+		t.Errorf("Unexpected first line: %q", html)
+	}
+	if state != (State{Foreground: 31, Bold: true}) {
+		t.Errorf("Unexpected state after first line: %+v", state)
+	}
+
+	html, state = ToHTML("failed\x1b[22m!\x1b[0m", state)
+	if html != `<span class="ansi-fg-31 ansi-bold">failed</span><span class="ansi-fg-31">!</span>` {
+		t.Errorf("Style was not continued on the next line: %q", html)
+	}
+	if state != (State{}) {
+		t.Errorf("State was not reset: %+v", state)
+	}
+}
+
+func TestStrip(t *testing.T) {
+	if s := Strip("\x1b[1;31m<b>\x1b[0m\x1b]0;title\x07 done\x1b[3"); s != "<b> done" {
+		t.Errorf("Unexpected stripped text: %q", s)
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
-	"github.com/Luzifer/go-openssl"
 	"github.com/Luzifer/gobuilder/builddb"
+	"github.com/Luzifer/gobuilder/buildjob"
+	"github.com/Luzifer/gobuilder/credentials"
+	"github.com/Luzifer/gobuilder/logstore"
+	"github.com/Luzifer/gobuilder/search"
+	"github.com/Luzifer/gobuilder/secrets"
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
-	"github.com/satori/go.uuid"
 	"gopkg.in/yaml.v2"
 )
 
@@ -20,19 +24,32 @@ func registerAPIv1(router *mux.Router) {
 	r.HandleFunc("/build", webhookInterface).Methods("POST")
 	r.HandleFunc("/webhook/github", webhookGitHub).Methods("POST")
 	r.HandleFunc("/webhook/bitbucket", webhookBitBucket).Methods("POST")
+	r.HandleFunc("/webhook/gitlab", webhookGitLab).Methods("POST")
 	r.HandleFunc("/webhook/cli", webhookCLI).Methods("POST")
 
 	r.HandleFunc("/{repo:.+}/last-build", apiV1HandlerLastBuild).Methods("GET")
 	r.HandleFunc("/{repo:.+}/already-built", apiV1HandlerAlreadyBuilt).Methods("GET")
 	r.HandleFunc("/{repo:.+}/signed-hashes/{tag}", apiV1HandlerSignedHashes).Methods("GET")
 	r.HandleFunc("/{repo:.+}/hashes/{tag}.{format:[a-z]+}", apiV1HandlerHashes).Methods("GET")
-	r.HandleFunc("/{repo:.+}/rebuild", apiV1HandlerRebuild).Methods("GET")
+	r.HandleFunc("/{repo:.+}/rebuild", apiV1HandlerRebuild).Methods("GET", "POST")
+	r.HandleFunc("/{repo:.+}/logs", apiV1HandlerLogs).Methods("GET")
+	r.HandleFunc("/{repo:.+}/logs/{logid}", apiV1HandlerLog).Methods("GET")
 	r.HandleFunc("/{repo:.+}/build.db", apiV1HandlerBuildDb).Methods("GET")
 	r.HandleFunc("/{repo:.+}/encrypt", apiV1HandlerEncrypt).Methods("POST")
+	r.HandleFunc("/{repo:.+}/credentials", apiV1HandlerCredentials).Methods("POST")
+	r.HandleFunc("/{repo:.+}/claim", apiV1HandlerClaim).Methods("POST")
+	r.HandleFunc("/{repo:.+}/cancel", apiV1HandlerCancel).Methods("POST")
+	r.HandleFunc("/{repo:.+}/delete-label", apiV1HandlerDeleteLabel).Methods("POST")
+	r.HandleFunc("/{repo:.+}/rotate-key", apiV1HandlerRotateKey).Methods("POST")
+	r.HandleFunc("/{repo:.+}/webhook-secret", apiV1HandlerWebhookSecret).Methods("POST")
 }
 
 func apiV1HandlerAlreadyBuilt(res http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if !checkRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
 	redisKey := fmt.Sprintf("project::%s::built-commits", vars["repo"])
 	commit := r.URL.Query().Get("commit")
 
@@ -60,11 +77,34 @@ func apiV1HandlerAlreadyBuilt(res http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func getKeyring(repo string) (*secrets.Keyring, error) {
+	return secrets.EnsureKeyring(redisClient, repo)
+}
+
+// wrapStoredKeyringKeys encrypts the keyring keys stored before they were
+// wrapped using the server-held key
+func wrapStoredKeyringKeys() {
+	n, err := secrets.WrapStoredKeys(redisClient)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Unable to wrap stored keyring keys")
+		return
+	}
+	if n > 0 {
+		log.WithFields(logrus.Fields{
+			"keys": n,
+		}).Info("Wrapped stored keyring keys")
+	}
+}
+
 func apiV1HandlerEncrypt(res http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	redisKey := fmt.Sprintf("project::%s::encryption-key", vars["repo"])
+	if !checkRepoOwner(res, r, vars["repo"], apiScopeAdmin) {
+		return
+	}
 
-	encryptionKey, err := redisClient.Get(redisKey)
+	keyring, err := getKeyring(vars["repo"])
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error": err,
@@ -74,20 +114,22 @@ func apiV1HandlerEncrypt(res http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if string(encryptionKey) == "" {
-		encryptionKey = []byte(uuid.NewV4().String())
-		redisClient.Set(redisKey, string(encryptionKey), 0, 0, false, false)
+	enc, err := keyring.Encrypt(r.FormValue("secret"))
+	if err != nil {
+		http.Error(res, "Could not encrypt secret", http.StatusInternalServerError)
+		return
 	}
 
-	o := openssl.New()
-	enc, err := o.EncryptString(string(encryptionKey), r.FormValue("secret"))
-
 	res.Header().Set("Content-Type", "text/plain")
-	res.Write(enc)
+	res.Write([]byte(enc))
 }
 
 func apiV1HandlerLastBuild(res http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if !checkRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
 	redisKey := fmt.Sprintf("project::%s::built-commits", vars["repo"])
 	commits, err := redisClient.ZRevRangeByScore(redisKey, "+inf", "-inf", true, true, 0, 1)
 	if err != nil {
@@ -99,6 +141,11 @@ func apiV1HandlerLastBuild(res http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(commits) == 0 {
+		http.Error(res, "No build found", http.StatusNotFound)
+		return
+	}
+
 	res.Header().Add("Content-Type", "text/plain")
 	res.Header().Add("Cache-Control", "no-cache")
 	res.Write([]byte(commits[0]))
@@ -106,6 +153,10 @@ func apiV1HandlerLastBuild(res http.ResponseWriter, r *http.Request) {
 
 func apiV1HandlerSignedHashes(res http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if !checkRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
 	redisKey := fmt.Sprintf("project::%s::hashes::%s", vars["repo"], vars["tag"])
 	hashList, err := redisClient.Get(redisKey)
 	if err != nil {
@@ -124,6 +175,10 @@ func apiV1HandlerSignedHashes(res http.ResponseWriter, r *http.Request) {
 
 func apiV1HandlerHashes(res http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if !checkRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
 	redisKey := fmt.Sprintf("project::%s::hashes_yml::%s", vars["repo"], vars["tag"])
 	hashList, err := redisClient.Get(redisKey)
 	if err != nil {
@@ -162,9 +217,14 @@ func apiV1HandlerHashes(res http.ResponseWriter, r *http.Request) {
 
 func apiV1HandlerBuildDb(res http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if !checkRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
 	buildDB, err := getBuildDBWithFallback(vars["repo"])
 	if err != nil {
 		http.Error(res, "Could not read build.db", http.StatusInternalServerError)
+		return
 	}
 
 	res.Header().Add("Content-Type", "application/json")
@@ -174,7 +234,306 @@ func apiV1HandlerBuildDb(res http.ResponseWriter, r *http.Request) {
 
 func apiV1HandlerRebuild(res http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	sendToQueue(parseRepoCommit(vars["repo"]))
+	repo, commit := parseRepoCommit(vars["repo"])
+	force := r.FormValue("force") == "true"
+
+	if !checkAPIScope(res, r, apiScopeBuild) {
+		return
+	}
+
+	if force {
+		// Forced rebuilds are owner-only and must not be triggered by links
+		if r.Method != "POST" {
+			http.Error(res, "Forced rebuilds need to be requested using POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if !checkRepoOwner(res, r, repo, apiScopeBuild) || !checkCSRF(res, r) {
+			return
+		}
+	}
+
+	enqueueJob(buildjob.BuildJob{
+		Repository: repo,
+		Commit:     commit,
+		Force:      force,
+	})
+
+	http.Redirect(res, r, fmt.Sprintf("/%s", repo), http.StatusFound)
+}
+
+func apiV1HandlerClaim(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	if !checkCSRF(res, r) {
+		return
+	}
+
+	if err := claimRepo(r, vars["repo"]); err != nil {
+		sess.AddFlash(err.Error(), "alert_error")
+	} else {
+		sess.AddFlash("You are now an owner of this repository.", "alert_success")
+	}
+
+	sess.Save(r, res)
+	http.Redirect(res, r, fmt.Sprintf("/%s", vars["repo"]), http.StatusFound)
+}
+
+func apiV1HandlerCancel(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	if !checkRepoOwner(res, r, vars["repo"], apiScopeBuild) || !checkCSRF(res, r) {
+		return
+	}
+
+	removed, err := removeQueuedJobs(func(j *buildjob.BuildJob) bool {
+		return j.Repository == vars["repo"]
+	})
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  vars["repo"],
+		}).Error("Failed to remove jobs from queue")
+		http.Error(res, "Could not cancel build", http.StatusInternalServerError)
+		return
+	}
+
+	buildStatus, _ := redisClient.Get(fmt.Sprintf("project::%s::build-status", vars["repo"]))
+	switch {
+	case string(buildStatus) == "building":
+		// The starter watches for this key and kills the running build
+		redisClient.Set(fmt.Sprintf("project::%s::cancel", vars["repo"]), "true", 1800, 0, false, false)
+	case removed > 0:
+		redisClient.Set(fmt.Sprintf("project::%s::abort", vars["repo"]), "Build was cancelled by an owner", 0, 0, false, false)
+		redisClient.Set(fmt.Sprintf("project::%s::build-status", vars["repo"]), "failed", 0, 0, false, false)
+	}
+
+	sess.AddFlash("The build has been cancelled.", "alert_success")
+	sess.Save(r, res)
+	http.Redirect(res, r, fmt.Sprintf("/%s", vars["repo"]), http.StatusFound)
+}
+
+func apiV1HandlerDeleteLabel(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	if !checkRepoOwner(res, r, vars["repo"], apiScopeAdmin) || !checkCSRF(res, r) {
+		return
+	}
+
+	label := r.FormValue("label")
+	if label == "" {
+		http.Error(res, "You must pass a label!", http.StatusBadRequest)
+		return
+	}
+
+	if err := deleteLabel(vars["repo"], label); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  vars["repo"],
+			"label": label,
+		}).Error("Failed to delete label")
+		http.Error(res, "Could not delete label", http.StatusInternalServerError)
+		return
+	}
+
+	sess.AddFlash(fmt.Sprintf("The artifacts of %s have been deleted.", label), "alert_success")
+	sess.Save(r, res)
+	http.Redirect(res, r, fmt.Sprintf("/%s", vars["repo"]), http.StatusFound)
+}
+
+func apiV1HandlerRotateKey(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	if !checkRepoOwner(res, r, vars["repo"], apiScopeAdmin) || !checkCSRF(res, r) {
+		return
+	}
+
+	keyring, err := getKeyring(vars["repo"])
+	if err != nil {
+		http.Error(res, "Could not read encryption key", http.StatusInternalServerError)
+		return
+	}
+
+	retire := r.FormValue("retire") == "true"
+	if retire {
+		keyring.Retire()
+	} else if err := keyring.Rotate(); err != nil {
+		http.Error(res, "Could not create encryption key", http.StatusInternalServerError)
+		return
+	}
+
+	// Fetch credentials are encrypted using the key of the source repository
+	if vars["repo"] == credentials.SourceRepository(vars["repo"]) {
+		if err := reencryptCredentials(vars["repo"], keyring); err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+				"repo":  vars["repo"],
+			}).Error("Failed to re-encrypt credentials")
+			http.Error(res, "Could not re-encrypt credentials", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := keyring.Save(redisClient, vars["repo"]); err != nil {
+		http.Error(res, "Could not store encryption key", http.StatusInternalServerError)
+		return
+	}
+
+	if retire {
+		sess.AddFlash("All previous encryption keys have been retired. Secrets encrypted using them can no longer be decrypted.", "alert_success")
+	} else {
+		sess.AddFlash("The encryption key has been rotated. Existing secrets keep working until you retire the previous keys, please encrypt them again.", "alert_success")
+	}
+	sess.Save(r, res)
+	http.Redirect(res, r, fmt.Sprintf("/%s", vars["repo"]), http.StatusFound)
+}
+
+// reencryptCredentials encrypts the fetch credentials using the current
+// key of the keyring. The keyring must still contain the former key.
+func reencryptCredentials(repo string, keyring *secrets.Keyring) error {
+	raw, err := redisClient.Get(credentials.RedisKey(repo))
+	if err != nil || len(raw) == 0 {
+		return err
+	}
+
+	creds, err := credentials.FromString(string(raw))
+	if err != nil {
+		return err
+	}
+
+	if !keyring.IsOutdated(creds.Secret) {
+		return nil
+	}
+
+	secret, err := keyring.Decrypt(creds.Secret)
+	if err != nil {
+		return err
+	}
+	if creds.Secret, err = keyring.Encrypt(secret); err != nil {
+		return err
+	}
+
+	data, err := creds.ToString()
+	if err != nil {
+		return err
+	}
+	return redisClient.Set(credentials.RedisKey(repo), data, 0, 0, false, false)
+}
+
+func apiV1HandlerCredentials(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	sourceRepo := credentials.SourceRepository(vars["repo"])
+
+	if !checkRepoOwner(res, r, sourceRepo, apiScopeAdmin) || !checkCSRF(res, r) {
+		return
+	}
+
+	secret := strings.TrimSpace(r.FormValue("secret"))
+	if secret == "" {
+		redisClient.Del(credentials.RedisKey(sourceRepo))
+		sess.AddFlash("The access credentials have been removed.", "alert_success")
+		sess.Save(r, res)
+		http.Redirect(res, r, fmt.Sprintf("/%s", vars["repo"]), http.StatusFound)
+		return
+	}
+
+	// Access to private code is only requested when it is needed
+	if getBearerToken(r) == "" && !hasPrivateRepoScope(r) {
+		sess.AddFlash("To build private repositories GoBuilder needs access to your private repositories on GitHub. Please grant it and save the credentials again.", "alert_error")
+		sess.Save(r, res)
+		http.Redirect(res, r, privateRepoLoginURL(vars["repo"]), http.StatusFound)
+		return
+	}
+
+	if !credentials.IsValidType(r.FormValue("type")) {
+		http.Error(res, "Unsupported type of credentials", http.StatusBadRequest)
+		return
+	}
 
+	keyring, err := getKeyring(sourceRepo)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  sourceRepo,
+		}).Error("Failed to get encryption key")
+		http.Error(res, "Could not read encryption key", http.StatusInternalServerError)
+		return
+	}
+
+	enc, err := keyring.Encrypt(secret)
+	if err != nil {
+		http.Error(res, "Could not encrypt credentials", http.StatusInternalServerError)
+		return
+	}
+
+	creds := credentials.FetchCredentials{
+		Type:   r.FormValue("type"),
+		Secret: enc,
+	}
+	data, err := creds.ToString()
+	if err != nil {
+		http.Error(res, "Could not encode credentials", http.StatusInternalServerError)
+		return
+	}
+
+	if err := redisClient.Set(credentials.RedisKey(sourceRepo), data, 0, 0, false, false); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  sourceRepo,
+		}).Error("Failed to store credentials")
+		http.Error(res, "Could not store credentials", http.StatusInternalServerError)
+		return
+	}
+
+	// Private repositories must not be listed publicly
+	redisClient.ZRem("last-builds", sourceRepo, vars["repo"])
+	search.Remove(redisClient, vars["repo"])
+
+	sess.AddFlash("The access credentials have been stored. Builds of this repository are now private.", "alert_success")
+	sess.Save(r, res)
 	http.Redirect(res, r, fmt.Sprintf("/%s", vars["repo"]), http.StatusFound)
 }
+
+func apiV1HandlerLogs(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !checkAPIScope(res, r, apiScopeReadLogs) || !checkRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	logs, err := redisClient.ZRevRange(fmt.Sprintf("project::%s::logs", vars["repo"]), 0, 100, false)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  vars["repo"],
+		}).Error("Failed to read build logs")
+		http.Error(res, "Could not read build logs", http.StatusInternalServerError)
+		return
+	}
+
+	logMetas := []*buildjob.BuildLog{}
+	for _, v := range logs {
+		if l, err := buildjob.LogFromString(v); err == nil {
+			logMetas = append(logMetas, l)
+		}
+	}
+
+	res.Header().Add("Content-Type", "application/json")
+	res.Header().Add("Cache-Control", "no-cache")
+	json.NewEncoder(res).Encode(logMetas)
+}
+
+func apiV1HandlerLog(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !checkAPIScope(res, r, apiScopeReadLogs) || !checkRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	buildLog, err := logstore.Read(redisClient, vars["repo"], vars["logid"])
+	if err != nil || buildLog == nil {
+		http.Error(res, "Not found", http.StatusNotFound)
+		return
+	}
+
+	res.Header().Add("Content-Type", "text/plain")
+	res.Write(buildLog)
+}
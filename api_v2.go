@@ -0,0 +1,869 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/gobuilder/builddb"
+	"github.com/Luzifer/gobuilder/buildjob"
+	"github.com/Luzifer/gobuilder/credentials"
+	"github.com/Luzifer/gobuilder/downloads"
+	"github.com/Luzifer/gobuilder/search"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+)
+
+const (
+	apiV2DefaultPerPage = 30
+	apiV2MaxPerPage     = 100
+	apiV2WorkerTimeout  = 300 * time.Second
+)
+
+// This block contains the error codes returned in API v2 error objects
+const (
+	apiV2ErrorInvalidRequest = "invalid_request"
+	apiV2ErrorUnauthorized   = "unauthorized"
+	apiV2ErrorForbidden      = "forbidden"
+	apiV2ErrorNotFound       = "not_found"
+	apiV2ErrorBlocked        = "repository_blocked"
+	apiV2ErrorInternal       = "internal_error"
+)
+
+type apiV2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiV2Pagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+type apiV2Response struct {
+	Data       interface{}      `json:"data"`
+	Pagination *apiV2Pagination `json:"pagination,omitempty"`
+}
+
+type apiV2ErrorResponse struct {
+	Error apiV2Error `json:"error"`
+}
+
+type apiV2Repository struct {
+	Name             string     `json:"name"`
+	SourceRepository string     `json:"source_repository"`
+	Private          bool       `json:"private"`
+	Status           string     `json:"status"`
+	AbortReason      string     `json:"abort_reason,omitempty"`
+	LastCommit       string     `json:"last_commit,omitempty"`
+	LastBuild        *time.Time `json:"last_build,omitempty"`
+	BuildDuration    int        `json:"build_duration"`
+	WebURL           string     `json:"web_url"`
+	LabelsURL        string     `json:"labels_url"`
+	BuildsURL        string     `json:"builds_url"`
+	DownloadsURL     string     `json:"downloads_url"`
+}
+
+type apiV2Label struct {
+	Name        string       `json:"name"`
+	GoVersion   string       `json:"go_version"`
+	BuildDate   time.Time    `json:"build_date"`
+	PullRequest bool         `json:"pull_request"`
+	Signed      bool         `json:"signed"`
+	Assets      []apiV2Asset `json:"assets,omitempty"`
+	AssetsURL   string       `json:"assets_url"`
+	HistoryURL  string       `json:"history_url"`
+}
+
+type apiV2LabelBuild struct {
+	ID        string       `json:"id"`
+	Label     string       `json:"label"`
+	Commit    string       `json:"commit,omitempty"`
+	GoVersion string       `json:"go_version"`
+	BuildDate time.Time    `json:"build_date"`
+	Assets    []apiV2Asset `json:"assets"`
+	BuildURL  string       `json:"build_url,omitempty"`
+}
+
+type apiV2Asset struct {
+	FileName    string `json:"file_name"`
+	Size        int64  `json:"size"`
+	BinarySize  int64  `json:"binary_size,omitempty"`
+	MD5         string `json:"md5"`
+	SHA1        string `json:"sha1"`
+	SHA256      string `json:"sha256"`
+	DownloadURL string `json:"download_url"`
+}
+
+type apiV2Build struct {
+	ID        string               `json:"id"`
+	Success   bool                 `json:"success"`
+	Time      time.Time            `json:"time"`
+	Steps     []apiV2BuildStep     `json:"steps,omitempty"`
+	Platforms []apiV2PlatformState `json:"platforms,omitempty"`
+	LogURL    string               `json:"log_url"`
+}
+
+type apiV2BuildStep struct {
+	Name     string  `json:"name"`
+	Line     int     `json:"line"`
+	Offset   float64 `json:"offset"`
+	Duration float64 `json:"duration"`
+}
+
+type apiV2PlatformState struct {
+	Platform string `json:"platform"`
+	Success  bool   `json:"success"`
+}
+
+type apiV2Commit struct {
+	Commit  string     `json:"commit"`
+	Built   bool       `json:"built"`
+	BuiltAt *time.Time `json:"built_at,omitempty"`
+}
+
+type apiV2Worker struct {
+	Hostname string    `json:"hostname"`
+	LastSeen time.Time `json:"last_seen"`
+	Active   bool      `json:"active"`
+}
+
+type apiV2QueueEntry struct {
+	Position    int    `json:"position"`
+	Repository  string `json:"repository"`
+	Commit      string `json:"commit,omitempty"`
+	Label       string `json:"label,omitempty"`
+	PullRequest int    `json:"pull_request,omitempty"`
+	Attempts    int    `json:"attempts"`
+}
+
+func registerAPIv2(router *mux.Router) {
+	r := router.PathPrefix("/api/v2/").Subrouter()
+
+	r.HandleFunc("/openapi.yaml", apiV2HandlerOpenAPI).Methods("GET")
+	r.HandleFunc("/workers", apiV2HandlerWorkers).Methods("GET")
+	r.HandleFunc("/queue", apiV2HandlerQueue).Methods("GET")
+	r.HandleFunc("/search", apiV2HandlerSearch).Methods("GET")
+
+	r.HandleFunc("/repositories/{repo:.+}/commits/{commit}", apiV2HandlerCommit).Methods("GET")
+	r.HandleFunc("/repositories/{repo:.+}/labels/{label}/assets", apiV2HandlerAssets).Methods("GET")
+	r.HandleFunc("/repositories/{repo:.+}/labels/{label}/history/{id}", apiV2HandlerLabelBuild).Methods("GET")
+	r.HandleFunc("/repositories/{repo:.+}/labels/{label}/history", apiV2HandlerLabelHistory).Methods("GET")
+	r.HandleFunc("/repositories/{repo:.+}/labels/{label}", apiV2HandlerLabel).Methods("GET")
+	r.HandleFunc("/repositories/{repo:.+}/labels", apiV2HandlerLabels).Methods("GET")
+	r.HandleFunc("/repositories/{repo:.+}/builds/{logid}", apiV2HandlerBuild).Methods("GET")
+	r.HandleFunc("/repositories/{repo:.+}/builds", apiV2HandlerBuilds).Methods("GET")
+	r.HandleFunc("/repositories/{repo:.+}/downloads", apiV2HandlerDownloads).Methods("GET")
+	r.HandleFunc("/repositories/{repo:.+}", apiV2HandlerRepository).Methods("GET")
+
+	r.NotFoundHandler = http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		apiV2WriteError(res, http.StatusNotFound, apiV2ErrorNotFound, "The requested resource does not exist")
+	})
+}
+
+func apiV2ErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return apiV2ErrorInvalidRequest
+	case http.StatusUnauthorized:
+		return apiV2ErrorUnauthorized
+	case http.StatusForbidden:
+		return apiV2ErrorForbidden
+	case http.StatusNotFound:
+		return apiV2ErrorNotFound
+	default:
+		return apiV2ErrorInternal
+	}
+}
+
+func apiV2WriteError(res http.ResponseWriter, status int, code, message string) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(apiV2ErrorResponse{
+		Error: apiV2Error{
+			Status:  status,
+			Code:    code,
+			Message: message,
+		},
+	})
+}
+
+// apiV2Write sends the data wrapped into the response envelope. The ETag
+// is derived from the body so clients are able to revalidate their copy
+// using If-None-Match without transferring the document again.
+func apiV2Write(res http.ResponseWriter, r *http.Request, data interface{}, pagination *apiV2Pagination) {
+	body, err := json.Marshal(apiV2Response{
+		Data:       data,
+		Pagination: pagination,
+	})
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"path":  r.URL.Path,
+		}).Error("Unable to encode API response")
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not encode response")
+		return
+	}
+
+	res.Header().Set("Cache-Control", "no-cache")
+	if pagination != nil {
+		apiV2SetLinkHeader(res, r, pagination)
+	}
+
+	writeWithETag(res, r, "application/json", body)
+}
+
+// writeWithETag sends the body with an ETag derived from it or responds
+// with 304 Not Modified if the client already has the current version
+func writeWithETag(res http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	etag := fmt.Sprintf("\"%x\"", sha256.Sum256(body))
+	res.Header().Set("ETag", etag)
+
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+		if match == etag || match == "*" {
+			res.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	res.Header().Set("Content-Type", contentType)
+	res.Write(body)
+}
+
+// apiV2Paginate reads the page and per_page parameters of the request and
+// returns the boundaries of the requested page inside a list of the given
+// length
+func apiV2Paginate(r *http.Request, total int) (int, int, *apiV2Pagination, error) {
+	p := &apiV2Pagination{
+		Page:    1,
+		PerPage: apiV2DefaultPerPage,
+		Total:   total,
+	}
+
+	if v := r.FormValue("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return 0, 0, nil, fmt.Errorf("Parameter page must be a positive number")
+		}
+		p.Page = page
+	}
+
+	if v := r.FormValue("per_page"); v != "" {
+		perPage, err := strconv.Atoi(v)
+		if err != nil || perPage < 1 || perPage > apiV2MaxPerPage {
+			return 0, 0, nil, fmt.Errorf("Parameter per_page must be between 1 and %d", apiV2MaxPerPage)
+		}
+		p.PerPage = perPage
+	}
+
+	p.TotalPages = (total + p.PerPage - 1) / p.PerPage
+
+	start := (p.Page - 1) * p.PerPage
+	if start > total {
+		start = total
+	}
+	end := start + p.PerPage
+	if end > total {
+		end = total
+	}
+
+	return start, end, p, nil
+}
+
+func apiV2SetLinkHeader(res http.ResponseWriter, r *http.Request, p *apiV2Pagination) {
+	pageURL := func(page int) string {
+		q := url.Values{}
+		for k, v := range r.URL.Query() {
+			q[k] = v
+		}
+		q.Set("page", strconv.Itoa(page))
+		q.Set("per_page", strconv.Itoa(p.PerPage))
+		return fmt.Sprintf("<%s?%s>", r.URL.Path, q.Encode())
+	}
+
+	links := []string{}
+	if p.Page > 1 {
+		links = append(links, pageURL(1)+"; rel=\"first\"", pageURL(p.Page-1)+"; rel=\"prev\"")
+	}
+	if p.Page < p.TotalPages {
+		links = append(links, pageURL(p.Page+1)+"; rel=\"next\"", pageURL(p.TotalPages)+"; rel=\"last\"")
+	}
+
+	if len(links) > 0 {
+		res.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// apiV2CheckRepoAccess ensures the repository is visible to the requesting
+// user and writes an error object if it isn't
+func apiV2CheckRepoAccess(res http.ResponseWriter, r *http.Request, repo string) bool {
+	if blocked, reason := blockedRepos.IsBlocked(repo); blocked {
+		apiV2WriteError(res, http.StatusNotFound, apiV2ErrorBlocked, "This repository is blocked: "+reason)
+		return false
+	}
+
+	if status, msg := verifyRepoAccess(r, repo); status != 0 {
+		apiV2WriteError(res, status, apiV2ErrorCode(status), msg)
+		return false
+	}
+
+	return true
+}
+
+// apiV2LoadBuildDB reads the build.db of the repository. Repositories
+// without any build yield an empty database.
+func apiV2LoadBuildDB(repo string) (builddb.BuildDB, error) {
+	buildDB := builddb.BuildDB{}
+
+	file, err := getBuildDBWithFallback(repo)
+	if err != nil {
+		return buildDB, nil
+	}
+
+	return buildDB, json.Unmarshal(file, &buildDB)
+}
+
+func apiV2RepoURL(repo string) string {
+	return "/api/v2/repositories/" + repo
+}
+
+func apiV2NewLabel(repo, name string, branch builddb.Branch, withAssets bool) apiV2Label {
+	signed, _ := redisClient.Exists(fmt.Sprintf("project::%s::signatures::%s", repo, name))
+
+	label := apiV2Label{
+		Name:        name,
+		GoVersion:   branch.GoVersion,
+		BuildDate:   branch.BuildDate,
+		PullRequest: builddb.IsPullRequestLabel(name),
+		Signed:      signed,
+		AssetsURL:   fmt.Sprintf("%s/labels/%s/assets", apiV2RepoURL(repo), name),
+		HistoryURL:  fmt.Sprintf("%s/labels/%s/history", apiV2RepoURL(repo), name),
+	}
+
+	if withAssets {
+		label.Assets = apiV2NewAssets(repo, branch.Assets)
+	}
+
+	return label
+}
+
+// apiV2DownloadURL returns the URL to download the asset from. Assets of
+// former builds are delivered from their immutable copy as the file named
+// by the label was overwritten by later builds.
+func apiV2DownloadURL(repo string, a builddb.Asset, historic bool) string {
+	if historic && a.Path != "" {
+		return "/get/" + a.Path
+	}
+	return fmt.Sprintf("/get/%s/%s", repo, a.FileName)
+}
+
+func apiV2NewAssets(repo string, assets []builddb.Asset) []apiV2Asset {
+	sort.Sort(builddb.ByFilename(assets))
+
+	out := []apiV2Asset{}
+	for _, a := range assets {
+		out = append(out, apiV2Asset{
+			FileName:    a.FileName,
+			Size:        a.Size,
+			BinarySize:  a.BinarySize,
+			MD5:         a.MD5,
+			SHA1:        a.SHA1,
+			SHA256:      a.SHA256,
+			DownloadURL: apiV2DownloadURL(repo, a, false),
+		})
+	}
+	return out
+}
+
+func apiV2NewBuild(repo string, l *buildjob.BuildLog, withSteps bool) apiV2Build {
+	build := apiV2Build{
+		ID:      l.ID,
+		Success: l.Success,
+		Time:    l.Time,
+		LogURL:  fmt.Sprintf("/%s/log/%s.json", repo, l.ID),
+	}
+
+	for _, p := range l.Platforms {
+		build.Platforms = append(build.Platforms, apiV2PlatformState{
+			Platform: p.Platform,
+			Success:  p.Success,
+		})
+	}
+
+	if withSteps {
+		for _, s := range l.Steps {
+			build.Steps = append(build.Steps, apiV2BuildStep{
+				Name:     s.Name,
+				Line:     s.Line,
+				Offset:   s.Offset.Seconds(),
+				Duration: s.Duration.Seconds(),
+			})
+		}
+	}
+
+	return build
+}
+
+func apiV2HandlerRepository(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !apiV2CheckRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	buildStatus, err := redisClient.Get(fmt.Sprintf("project::%s::build-status", vars["repo"]))
+	if err != nil || buildStatus == nil {
+		apiV2WriteError(res, http.StatusNotFound, apiV2ErrorNotFound, "This repository is not yet known")
+		return
+	}
+
+	repo := apiV2Repository{
+		Name:             vars["repo"],
+		SourceRepository: credentials.SourceRepository(vars["repo"]),
+		Private:          isPrivateRepo(vars["repo"]),
+		Status:           string(buildStatus),
+		WebURL:           "/" + vars["repo"],
+		LabelsURL:        apiV2RepoURL(vars["repo"]) + "/labels",
+		BuildsURL:        apiV2RepoURL(vars["repo"]) + "/builds",
+		DownloadsURL:     apiV2RepoURL(vars["repo"]) + "/downloads",
+	}
+
+	if abortReason, err := redisClient.Get(fmt.Sprintf("project::%s::abort", vars["repo"])); err == nil {
+		repo.AbortReason = string(abortReason)
+	}
+
+	if duration, err := redisClient.Get(fmt.Sprintf("project::%s::build-duration", vars["repo"])); err == nil {
+		repo.BuildDuration, _ = strconv.Atoi(string(duration))
+	}
+
+	commits, err := redisClient.ZRevRangeByScore(fmt.Sprintf("project::%s::built-commits", vars["repo"]), "+inf", "-inf", true, true, 0, 1)
+	if err == nil && len(commits) == 2 {
+		repo.LastCommit = commits[0]
+		if ts, err := strconv.ParseFloat(commits[1], 64); err == nil {
+			t := time.Unix(int64(ts), 0)
+			repo.LastBuild = &t
+		}
+	}
+
+	apiV2Write(res, r, repo, nil)
+}
+
+func apiV2HandlerDownloads(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !apiV2CheckRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	days := 30
+	if v := r.FormValue("days"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 1 || d > downloads.DailyRetention {
+			apiV2WriteError(res, http.StatusBadRequest, apiV2ErrorInvalidRequest,
+				fmt.Sprintf("days must be a number between 1 and %d", downloads.DailyRetention))
+			return
+		}
+		days = d
+	}
+
+	stats, err := downloads.Get(redisClient, vars["repo"], days)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  vars["repo"],
+		}).Error("Unable to load download statistics")
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Unable to load download statistics")
+		return
+	}
+
+	apiV2Write(res, r, stats, nil)
+}
+
+func apiV2HandlerCommit(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !apiV2CheckRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	score, err := redisClient.ZScore(fmt.Sprintf("project::%s::built-commits", vars["repo"]), vars["commit"])
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error":  err,
+			"repo":   vars["repo"],
+			"commit": vars["commit"],
+		}).Error("Failed to read commit score")
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read built commits")
+		return
+	}
+
+	commit := apiV2Commit{Commit: vars["commit"]}
+	if score != nil {
+		commit.Built = true
+		if ts, err := strconv.ParseFloat(string(score), 64); err == nil {
+			t := time.Unix(int64(ts), 0)
+			commit.BuiltAt = &t
+		}
+	}
+
+	apiV2Write(res, r, commit, nil)
+}
+
+func apiV2HandlerLabels(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !apiV2CheckRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	buildDB, err := apiV2LoadBuildDB(vars["repo"])
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  vars["repo"],
+		}).Error("Failed to read build.db")
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read build.db")
+		return
+	}
+
+	withPullRequests := r.FormValue("pull_requests") == "true"
+	branches := []builddb.BranchSortEntry{}
+	for k, v := range buildDB {
+		if builddb.IsPullRequestLabel(k) && !withPullRequests {
+			continue
+		}
+		branches = append(branches, builddb.BranchSortEntry{Branch: k, BuildDate: v.BuildDate})
+	}
+	sort.Sort(sort.Reverse(builddb.BranchSortEntryByBuildDate(branches)))
+
+	start, end, pagination, err := apiV2Paginate(r, len(branches))
+	if err != nil {
+		apiV2WriteError(res, http.StatusBadRequest, apiV2ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	labels := []apiV2Label{}
+	for _, b := range branches[start:end] {
+		labels = append(labels, apiV2NewLabel(vars["repo"], b.Branch, buildDB[b.Branch], false))
+	}
+
+	apiV2Write(res, r, labels, pagination)
+}
+
+func apiV2HandlerLabel(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !apiV2CheckRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	buildDB, err := apiV2LoadBuildDB(vars["repo"])
+	if err != nil {
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read build.db")
+		return
+	}
+
+	branch, ok := buildDB[vars["label"]]
+	if !ok {
+		apiV2WriteError(res, http.StatusNotFound, apiV2ErrorNotFound, "This label does not exist")
+		return
+	}
+
+	apiV2Write(res, r, apiV2NewLabel(vars["repo"], vars["label"], branch, true), nil)
+}
+
+func apiV2HandlerAssets(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !apiV2CheckRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	buildDB, err := apiV2LoadBuildDB(vars["repo"])
+	if err != nil {
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read build.db")
+		return
+	}
+
+	branch, ok := buildDB[vars["label"]]
+	if !ok {
+		apiV2WriteError(res, http.StatusNotFound, apiV2ErrorNotFound, "This label does not exist")
+		return
+	}
+
+	assets := apiV2NewAssets(vars["repo"], branch.Assets)
+	start, end, pagination, err := apiV2Paginate(r, len(assets))
+	if err != nil {
+		apiV2WriteError(res, http.StatusBadRequest, apiV2ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	apiV2Write(res, r, assets[start:end], pagination)
+}
+
+func apiV2NewLabelBuild(repo string, b builddb.Build) apiV2LabelBuild {
+	out := apiV2LabelBuild{
+		ID:        b.ID,
+		Label:     b.Label,
+		Commit:    b.Commit,
+		GoVersion: b.GoVersion,
+		BuildDate: b.BuildDate,
+		Assets:    apiV2NewAssets(repo, b.Assets),
+	}
+
+	for i, a := range b.Assets {
+		out.Assets[i].DownloadURL = apiV2DownloadURL(repo, a, true)
+	}
+
+	if b.HasLog() {
+		out.BuildURL = fmt.Sprintf("%s/builds/%s", apiV2RepoURL(repo), b.ID)
+	}
+
+	return out
+}
+
+func apiV2HandlerLabelHistory(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !apiV2CheckRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	buildDB, err := apiV2LoadBuildDB(vars["repo"])
+	if err != nil {
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read build.db")
+		return
+	}
+
+	if err := builddb.Migrate(redisClient, vars["repo"], buildDB); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  vars["repo"],
+		}).Error("Unable to migrate build history")
+	}
+
+	total, err := builddb.HistoryLength(redisClient, vars["repo"], vars["label"])
+	if err != nil {
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read build history")
+		return
+	}
+	if total == 0 {
+		apiV2WriteError(res, http.StatusNotFound, apiV2ErrorNotFound, "This label does not exist")
+		return
+	}
+
+	start, end, pagination, err := apiV2Paginate(r, total)
+	if err != nil {
+		apiV2WriteError(res, http.StatusBadRequest, apiV2ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	builds := []apiV2LabelBuild{}
+	if end > start {
+		history, err := builddb.History(redisClient, vars["repo"], vars["label"], start, end-1)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+				"repo":  vars["repo"],
+				"label": vars["label"],
+			}).Error("Failed to read build history")
+			apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read build history")
+			return
+		}
+
+		for _, b := range history {
+			builds = append(builds, apiV2NewLabelBuild(vars["repo"], b))
+		}
+	}
+
+	apiV2Write(res, r, builds, pagination)
+}
+
+func apiV2HandlerLabelBuild(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !apiV2CheckRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	b, err := builddb.GetBuild(redisClient, vars["repo"], vars["label"], vars["id"])
+	if err != nil {
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read build history")
+		return
+	}
+	if b == nil {
+		apiV2WriteError(res, http.StatusNotFound, apiV2ErrorNotFound, "This build does not exist")
+		return
+	}
+
+	apiV2Write(res, r, apiV2NewLabelBuild(vars["repo"], *b), nil)
+}
+
+func apiV2HandlerBuilds(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !apiV2CheckRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	redisKey := fmt.Sprintf("project::%s::logs", vars["repo"])
+	total, err := redisClient.ZCard(redisKey)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  vars["repo"],
+		}).Error("Failed to count build logs")
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read builds")
+		return
+	}
+
+	start, end, pagination, err := apiV2Paginate(r, int(total))
+	if err != nil {
+		apiV2WriteError(res, http.StatusBadRequest, apiV2ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	builds := []apiV2Build{}
+	if end > start {
+		logs, err := redisClient.ZRevRange(redisKey, start, end-1, false)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+				"repo":  vars["repo"],
+			}).Error("Failed to read build logs")
+			apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read builds")
+			return
+		}
+
+		for _, v := range logs {
+			if l, err := buildjob.LogFromString(v); err == nil {
+				builds = append(builds, apiV2NewBuild(vars["repo"], l, false))
+			}
+		}
+	}
+
+	apiV2Write(res, r, builds, pagination)
+}
+
+func apiV2HandlerBuild(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !apiV2CheckRepoAccess(res, r, vars["repo"]) {
+		return
+	}
+
+	l := getBuildLogMeta(vars["repo"], vars["logid"])
+	if l == nil {
+		apiV2WriteError(res, http.StatusNotFound, apiV2ErrorNotFound, "This build does not exist")
+		return
+	}
+
+	apiV2Write(res, r, apiV2NewBuild(vars["repo"], l, true), nil)
+}
+
+func apiV2HandlerWorkers(res http.ResponseWriter, r *http.Request) {
+	entries, err := redisClient.ZRevRangeByScore("active-workers", "+inf", "-inf", true, false, 0, 0)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Failed to read active workers")
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read workers")
+		return
+	}
+
+	workers := []apiV2Worker{}
+	for i := 0; i+1 < len(entries); i += 2 {
+		ts, err := strconv.ParseFloat(entries[i+1], 64)
+		if err != nil {
+			continue
+		}
+		lastSeen := time.Unix(int64(ts), 0)
+		workers = append(workers, apiV2Worker{
+			Hostname: entries[i],
+			LastSeen: lastSeen,
+			Active:   time.Since(lastSeen) < apiV2WorkerTimeout,
+		})
+	}
+
+	start, end, pagination, err := apiV2Paginate(r, len(workers))
+	if err != nil {
+		apiV2WriteError(res, http.StatusBadRequest, apiV2ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	apiV2Write(res, r, workers[start:end], pagination)
+}
+
+func apiV2HandlerQueue(res http.ResponseWriter, r *http.Request) {
+	queueItems, err := redisClient.LRange("build-queue", 0, -1)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Failed to read build queue")
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not read build queue")
+		return
+	}
+
+	entries := []apiV2QueueEntry{}
+	for i, item := range queueItems {
+		j, err := buildjob.FromBytes([]byte(item))
+		if err != nil {
+			continue
+		}
+
+		// Jobs of private repositories are only listed to users able to
+		// see the repository itself
+		if status, _ := verifyRepoAccess(r, j.Repository); status != 0 {
+			continue
+		}
+
+		entries = append(entries, apiV2QueueEntry{
+			Position:    i + 1,
+			Repository:  j.Repository,
+			Commit:      j.Commit,
+			Label:       j.Label,
+			PullRequest: j.PullRequest,
+			Attempts:    j.NumberOfExecutions,
+		})
+	}
+
+	start, end, pagination, err := apiV2Paginate(r, len(entries))
+	if err != nil {
+		apiV2WriteError(res, http.StatusBadRequest, apiV2ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	apiV2Write(res, r, entries[start:end], pagination)
+}
+
+func apiV2HandlerSearch(res http.ResponseWriter, r *http.Request) {
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		apiV2WriteError(res, http.StatusBadRequest, apiV2ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	results, err := search.Search(redisClient, q)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"query": q.Term,
+		}).Error("Search failed")
+		apiV2WriteError(res, http.StatusInternalServerError, apiV2ErrorInternal, "Could not search repositories")
+		return
+	}
+
+	start, end, pagination, err := apiV2Paginate(r, len(results))
+	if err != nil {
+		apiV2WriteError(res, http.StatusBadRequest, apiV2ErrorInvalidRequest, err.Error())
+		return
+	}
+
+	apiV2Write(res, r, results[start:end], pagination)
+}
+
+func apiV2HandlerOpenAPI(res http.ResponseWriter, r *http.Request) {
+	res.Header().Set("Content-Type", "application/x-yaml")
+	http.ServeFile(res, r, "frontend/openapi.yaml")
+}
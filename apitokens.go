@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/flosch/pongo2"
+)
+
+// This block contains the scopes an API token can be restricted to
+const (
+	apiScopeBuild    = "build"
+	apiScopeReadLogs = "read-logs"
+	apiScopeAdmin    = "admin"
+)
+
+var apiScopes = []string{apiScopeBuild, apiScopeReadLogs, apiScopeAdmin}
+
+type apiToken struct {
+	ID      string
+	Name    string
+	Login   string
+	Scopes  []string
+	Created time.Time
+}
+
+// HasScope checks whether the token is allowed to be used for the scope
+func (a *apiToken) HasScope(scope string) bool {
+	for _, s := range a.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type apiTokensByCreation []apiToken
+
+func (a apiTokensByCreation) Len() int           { return len(a) }
+func (a apiTokensByCreation) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a apiTokensByCreation) Less(i, j int) bool { return a[i].Created.Before(a[j].Created) }
+
+func apiTokenKey(id string) string {
+	return fmt.Sprintf("api-token::%s", id)
+}
+
+// apiTokenID derives the ID from the token itself so the plain token
+// never needs to be stored
+func apiTokenID(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))[0:32]
+}
+
+func createAPIToken(login, name string, scopes []string) (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := fmt.Sprintf("gbt_%x", buf)
+	id := apiTokenID(token)
+
+	if err := redisClient.HMSet(apiTokenKey(id), map[string]string{
+		"name":    name,
+		"login":   login,
+		"scopes":  strings.Join(scopes, ","),
+		"created": strconv.FormatInt(time.Now().Unix(), 10),
+	}); err != nil {
+		return "", err
+	}
+
+	if _, err := redisClient.SAdd(userKey(login)+"::tokens", id); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func loadAPIToken(id string) (*apiToken, error) {
+	fields, err := redisClient.HGetAll(apiTokenKey(id))
+	if err != nil {
+		return nil, err
+	}
+	if fields["login"] == "" {
+		return nil, fmt.Errorf("Token not found")
+	}
+
+	created, _ := strconv.ParseInt(fields["created"], 10, 64)
+	return &apiToken{
+		ID:      id,
+		Name:    fields["name"],
+		Login:   fields["login"],
+		Scopes:  strings.Split(fields["scopes"], ","),
+		Created: time.Unix(created, 0),
+	}, nil
+}
+
+func listAPITokens(login string) ([]apiToken, error) {
+	ids, err := redisClient.SMembers(userKey(login) + "::tokens")
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []apiToken{}
+	for _, id := range ids {
+		t, err := loadAPIToken(id)
+		if err != nil {
+			continue
+		}
+		tokens = append(tokens, *t)
+	}
+
+	sort.Sort(sort.Reverse(apiTokensByCreation(tokens)))
+	return tokens, nil
+}
+
+func revokeAPIToken(login, id string) error {
+	t, err := loadAPIToken(id)
+	if err != nil {
+		return err
+	}
+	if t.Login != login {
+		return fmt.Errorf("Token not found")
+	}
+
+	if _, err := redisClient.Del(apiTokenKey(id)); err != nil {
+		return err
+	}
+	_, err = redisClient.SRem(userKey(login)+"::tokens", id)
+	return err
+}
+
+// getBearerToken extracts the token from the Authorization header
+func getBearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+}
+
+// getRequestAPIToken returns the API token the request was authenticated
+// with or nil if no (valid) token was passed
+func getRequestAPIToken(r *http.Request) *apiToken {
+	token := getBearerToken(r)
+	if token == "" {
+		return nil
+	}
+
+	t, err := loadAPIToken(apiTokenID(token))
+	if err != nil {
+		return nil
+	}
+	return t
+}
+
+// getRequestUser returns the login of the user authenticated by API token
+// or by the GitHub login stored in the session
+func getRequestUser(r *http.Request) string {
+	if getBearerToken(r) != "" {
+		if t := getRequestAPIToken(r); t != nil {
+			return t.Login
+		}
+		return ""
+	}
+
+	return getGithubUsername(r)
+}
+
+// verifyAPIScope checks requests authenticated using an API token are
+// only executing actions the token was created for. Requests without
+// token are passed through. If the check fails the HTTP status and a
+// message describing the failure are returned, otherwise 0.
+func verifyAPIScope(r *http.Request, scope string) (int, string) {
+	if getBearerToken(r) == "" {
+		return 0, ""
+	}
+
+	t := getRequestAPIToken(r)
+	if t == nil {
+		return http.StatusUnauthorized, "Invalid API token"
+	}
+
+	if !t.HasScope(scope) {
+		return http.StatusForbidden, fmt.Sprintf("API token is missing the %q scope", scope)
+	}
+
+	return 0, ""
+}
+
+// checkAPIScope ensures the API token used for the request carries the
+// scope. If the check fails an error is written and false is returned.
+func checkAPIScope(res http.ResponseWriter, r *http.Request, scope string) bool {
+	if status, msg := verifyAPIScope(r, scope); status != 0 {
+		http.Error(res, msg, status)
+		return false
+	}
+	return true
+}
+
+func handleAPITokens(res http.ResponseWriter, r *http.Request) {
+	login := getGithubUsername(r)
+	if login == "" {
+		http.Redirect(res, r, "/ghlogin", http.StatusFound)
+		return
+	}
+
+	tokens, err := listAPITokens(login)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"user":  login,
+		}).Error("Unable to list API tokens")
+		http.Error(res, "An unknown error occured.", http.StatusInternalServerError)
+		return
+	}
+
+	template := pongo2.Must(pongo2.FromFile("frontend/tokens.html"))
+	ctx := getBasicContext(res, r)
+	ctx["tokens"] = tokens
+	ctx["scopes"] = apiScopes
+
+	template.ExecuteWriter(ctx, res)
+}
+
+func handleAPITokenCreate(res http.ResponseWriter, r *http.Request) {
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	login := getGithubUsername(r)
+	if login == "" {
+		http.Redirect(res, r, "/ghlogin", http.StatusFound)
+		return
+	}
+	if !checkCSRF(res, r) {
+		return
+	}
+
+	r.ParseForm()
+	scopes := []string{}
+	for _, s := range apiScopes {
+		for _, requested := range r.Form["scope"] {
+			if s == requested {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(scopes) == 0 {
+		sess.AddFlash("Please provide a name and at least one scope for your token.", "alert_error")
+		sess.Save(r, res)
+		http.Redirect(res, r, "/tokens", http.StatusFound)
+		return
+	}
+
+	token, err := createAPIToken(login, name, scopes)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"user":  login,
+		}).Error("Unable to create API token")
+		sess.AddFlash("An unknown error occured while creating your token.", "alert_error")
+		sess.Save(r, res)
+		http.Redirect(res, r, "/tokens", http.StatusFound)
+		return
+	}
+
+	sess.AddFlash(flashContext{
+		"success":   "Your token has been created. Copy it now, it will not be shown again.",
+		"new_token": token,
+	}, "context")
+	sess.Save(r, res)
+	http.Redirect(res, r, "/tokens", http.StatusFound)
+}
+
+func handleAPITokenRevoke(res http.ResponseWriter, r *http.Request) {
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	login := getGithubUsername(r)
+	if login == "" {
+		http.Redirect(res, r, "/ghlogin", http.StatusFound)
+		return
+	}
+	if !checkCSRF(res, r) {
+		return
+	}
+
+	if err := revokeAPIToken(login, r.FormValue("id")); err != nil {
+		sess.AddFlash("The token could not be revoked.", "alert_error")
+	} else {
+		sess.AddFlash("The token has been revoked.", "alert_success")
+	}
+
+	sess.Save(r, res)
+	http.Redirect(res, r, "/tokens", http.StatusFound)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Luzifer/gobuilder/assetstore"
+	"github.com/Luzifer/gobuilder/builddb"
+)
+
+// deleteLabel removes the assets of the label from the storage and drops
+// all metadata stored for that label
+func deleteLabel(repository, label string) error {
+	file, err := getBuildDBWithFallback(repository)
+	if err == nil {
+		buildDB := builddb.BuildDB{}
+		if err := json.Unmarshal(file, &buildDB); err != nil {
+			return err
+		}
+
+		if branch, ok := buildDB[label]; ok {
+			names := []string{fmt.Sprintf("%s_README.md", label)}
+			for _, asset := range branch.Assets {
+				binary := strings.TrimSuffix(asset.FileName, ".zip")
+				names = append(names, asset.FileName, binary, binary+".exe")
+			}
+			for _, name := range names {
+				s3Bucket.Del(fmt.Sprintf("%s/%s", repository, name))
+			}
+			// The objects are removed by the garbage collection once unreferenced
+			if err := assetstore.RemoveRefs(redisClient, repository, names...); err != nil {
+				return err
+			}
+
+			delete(buildDB, label)
+			db, err := json.Marshal(buildDB)
+			if err != nil {
+				return err
+			}
+			if err := redisClient.Set(fmt.Sprintf("project::%s::builddb", repository), string(db), 0, 0, false, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := builddb.RemoveLabel(redisClient, repository, label); err != nil {
+		return err
+	}
+
+	_, err = redisClient.Del(
+		fmt.Sprintf("project::%s::signatures::%s", repository, label),
+		fmt.Sprintf("project::%s::hashes::%s", repository, label),
+		fmt.Sprintf("project::%s::hashes_yml::%s", repository, label),
+	)
+	return err
+}
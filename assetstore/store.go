@@ -0,0 +1,149 @@
+package assetstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"launchpad.net/goamz/s3"
+
+	"github.com/xuyu/goredis"
+)
+
+// ObjectPrefix returns the prefix below which the objects of the repository
+// are stored named by the SHA256 of their content
+func ObjectPrefix(repo string) string {
+	return fmt.Sprintf("%s/.objects/", repo)
+}
+
+// ObjectPath returns the storage key of the object having the given SHA256
+func ObjectPath(repo, sha string) string {
+	return ObjectPrefix(repo) + sha
+}
+
+// RefsKey returns the key of the hash mapping the file names of the assets
+// (zips, binaries and READMEs of all labels) to the SHA256 of their object
+func RefsKey(repo string) string {
+	return fmt.Sprintf("project::%s::assets", repo)
+}
+
+// SetRef points the file name of the repository to the object
+func SetRef(redisClient *goredis.Redis, repo, name, sha string) error {
+	_, err := redisClient.HSet(RefsKey(repo), name, sha)
+	return err
+}
+
+// RemoveRefs drops the references of the file names. The objects are left
+// for the garbage collection as other names might still point to them.
+func RemoveRefs(redisClient *goredis.Redis, repo string, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	_, err := redisClient.HDel(RefsKey(repo), names...)
+	return err
+}
+
+// Refs returns all file names of the repository and their SHA256
+func Refs(redisClient *goredis.Redis, repo string) (map[string]string, error) {
+	return redisClient.HGetAll(RefsKey(repo))
+}
+
+// Resolve returns the storage key of the file stored as "<repo>/<name>".
+// Files uploaded before the content-addressed storage are not referenced
+// and resolve to their own key.
+func Resolve(redisClient *goredis.Redis, file string) (string, error) {
+	repo, name := path.Dir(file), path.Base(file)
+
+	sha, err := redisClient.HGet(RefsKey(repo), name)
+	if err != nil {
+		return "", err
+	}
+	if len(sha) == 0 {
+		return file, nil
+	}
+
+	return ObjectPath(repo, string(sha)), nil
+}
+
+// Get fetches the content of the file stored as "<repo>/<name>"
+func Get(redisClient *goredis.Redis, bucket *s3.Bucket, file string) ([]byte, error) {
+	key, err := Resolve(redisClient, file)
+	if err != nil {
+		return nil, err
+	}
+	return bucket.Get(key)
+}
+
+// SignedURL returns a signed URL to the object stored at key which is
+// downloaded using the given file name instead of the name of the object
+func SignedURL(bucket *s3.Bucket, key, filename string, expires time.Time) (string, error) {
+	disposition := fmt.Sprintf("attachment; filename=%q", filename)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+
+	// Query string authentication, the sub-resource values are signed unencoded
+	payload := strings.Join([]string{
+		"GET", "", "", exp,
+		fmt.Sprintf("/%s/%s?response-content-disposition=%s", bucket.Name, key, disposition),
+	}, "\n")
+	mac := hmac.New(sha1.New, []byte(bucket.S3.Auth.SecretKey))
+	mac.Write([]byte(payload))
+
+	var u *url.URL
+	var err error
+	if bucket.S3.Region.S3BucketEndpoint != "" {
+		u, err = url.Parse(strings.Replace(bucket.S3.Region.S3BucketEndpoint, "${bucket}", bucket.Name, -1))
+		if err == nil {
+			u.Path = "/" + key
+		}
+	} else {
+		u, err = url.Parse(bucket.S3.Region.S3Endpoint)
+		if err == nil {
+			u.Path = fmt.Sprintf("/%s/%s", bucket.Name, key)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	u.RawQuery = url.Values{
+		"AWSAccessKeyId":               {bucket.S3.Auth.AccessKey},
+		"Expires":                      {exp},
+		"Signature":                    {base64.StdEncoding.EncodeToString(mac.Sum(nil))},
+		"response-content-disposition": {disposition},
+	}.Encode()
+
+	return u.String(), nil
+}
+
+// Lister lists the objects of a bucket, it is implemented by *s3.Bucket
+type Lister interface {
+	List(prefix, delim, marker string, max int) (*s3.ListResp, error)
+}
+
+// Objects lists the objects stored for the repository by their SHA256
+func Objects(bucket Lister, repo string) (map[string]s3.Key, error) {
+	objects := map[string]s3.Key{}
+
+	marker := ""
+	for {
+		list, err := bucket.List(ObjectPrefix(repo), "", marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, k := range list.Contents {
+			marker = k.Key
+			objects[strings.TrimPrefix(k.Key, ObjectPrefix(repo))] = k
+		}
+
+		if !list.IsTruncated || len(list.Contents) == 0 {
+			return objects, nil
+		}
+	}
+}
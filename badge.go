@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/Luzifer/gobuilder/builddb"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+)
+
+const badgeCacheSeconds = 300
+
+var badgeColors = map[string]string{
+	"brightgreen": "#4c1",
+	"red":         "#e05d44",
+	"yellow":      "#dfb317",
+	"blue":        "#007ec6",
+	"lightgrey":   "#9f9f9f",
+}
+
+// badge is the content of a badge and at the same time the JSON document
+// expected by shields.io endpoint badges
+type badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+	CacheSeconds  int    `json:"cacheSeconds"`
+}
+
+func handleBadgeSVG(res http.ResponseWriter, r *http.Request) {
+	b, private := getBadge(r, mux.Vars(r)["repo"])
+	setBadgeCacheHeader(res, private)
+	writeWithETag(res, r, "image/svg+xml", []byte(b.SVG()))
+}
+
+func handleBadgeJSON(res http.ResponseWriter, r *http.Request) {
+	b, private := getBadge(r, mux.Vars(r)["repo"])
+	body, err := json.Marshal(b)
+	if err != nil {
+		http.Error(res, "Could not encode badge", http.StatusInternalServerError)
+		return
+	}
+
+	setBadgeCacheHeader(res, private)
+	writeWithETag(res, r, "application/json", body)
+}
+
+// badgeURL returns the absolute URL of the status badge of the repository
+func badgeURL(repo string) string {
+	return absoluteURL(fmt.Sprintf("/badge/%s.svg", repo))
+}
+
+func setBadgeCacheHeader(res http.ResponseWriter, private bool) {
+	scope := "public"
+	if private {
+		scope = "private"
+	}
+	res.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, badgeCacheSeconds))
+}
+
+// getBadge collects the badge requested by the type parameter for the label
+// parameter (defaults to master or the label built last). Repositories the
+// client has no access to are reported as unknown.
+func getBadge(r *http.Request, repo string) (badge, bool) {
+	b := badge{
+		SchemaVersion: 1,
+		Label:         "gobuilder",
+		Message:       "unknown",
+		Color:         "lightgrey",
+		CacheSeconds:  badgeCacheSeconds,
+	}
+
+	badgeType := r.FormValue("type")
+	switch badgeType {
+	case "date":
+		b.Label = "last build"
+	case "go":
+		b.Label = "go"
+	}
+
+	if blocked, _ := blockedRepos.IsBlocked(repo); blocked {
+		b.Message = "blocked"
+		return b, false
+	}
+
+	private := isPrivateRepo(repo)
+	if status, _ := verifyRepoAccess(r, repo); status != 0 {
+		return b, private
+	}
+
+	buildDB := builddb.BuildDB{}
+	if raw, err := redisClient.Get(fmt.Sprintf("project::%s::builddb", repo)); err == nil && len(raw) > 0 {
+		if err := json.Unmarshal(raw, &buildDB); err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+				"repo":  repo,
+			}).Error("Unable to parse build DB for badge")
+		}
+	}
+
+	label := r.FormValue("label")
+	if label == "" {
+		label = badgeDefaultLabel(buildDB)
+	}
+	branch, hasLabel := buildDB[label]
+
+	switch badgeType {
+	case "date":
+		if hasLabel {
+			b.Message = branch.BuildDate.UTC().Format("2006-01-02")
+			b.Color = "blue"
+		}
+
+	case "go":
+		if hasLabel {
+			b.Message = badgeGoVersion(branch.GoVersion)
+			b.Color = "blue"
+		}
+
+	default:
+		statusKey := fmt.Sprintf("project::%s::build-status", repo)
+		if builddb.IsPullRequestLabel(label) {
+			statusKey = fmt.Sprintf("%s::%s", statusKey, label)
+		}
+		status, _ := redisClient.Get(statusKey)
+
+		switch string(status) {
+		case "finished":
+			b.Message, b.Color = "passing", "brightgreen"
+		case "failed":
+			b.Message, b.Color = "failing", "red"
+		case "building":
+			b.Message, b.Color = "building", "yellow"
+		case "queued":
+			b.Message, b.Color = "queued", "lightgrey"
+		}
+	}
+
+	return b, private
+}
+
+// badgeDefaultLabel returns master if it was built or the label built last
+func badgeDefaultLabel(buildDB builddb.BuildDB) string {
+	if _, ok := buildDB["master"]; ok {
+		return "master"
+	}
+
+	label := ""
+	for k, v := range buildDB {
+		if builddb.IsPullRequestLabel(k) {
+			continue
+		}
+		if label == "" || v.BuildDate.After(buildDB[label].BuildDate) {
+			label = k
+		}
+	}
+	return label
+}
+
+// badgeGoVersion extracts the version from the output of `go version`
+// ("go version go1.5.1 linux/amd64")
+func badgeGoVersion(goVersion string) string {
+	for _, part := range strings.Fields(goVersion) {
+		if strings.HasPrefix(part, "go") && part != "go" && part != "go:" {
+			return strings.TrimPrefix(part, "go")
+		}
+	}
+	return "unknown"
+}
+
+// SVG renders the badge in the flat style known from shields.io
+func (b badge) SVG() string {
+	labelWidth := badgeTextWidth(b.Label) + 10
+	messageWidth := badgeTextWidth(b.Message) + 10
+	width := labelWidth + messageWidth
+
+	color, ok := badgeColors[b.Color]
+	if !ok {
+		color = badgeColors["lightgrey"]
+	}
+
+	label, message := html.EscapeString(b.Label), html.EscapeString(b.Message)
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">`+
+		`<title>%[4]s: %[5]s</title>`+
+		`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`+
+		`<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>`+
+		`<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]d" y="14">%[4]s</text>`+
+		`<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]d" y="14">%[5]s</text>`+
+		`</g></svg>`,
+		width, labelWidth, messageWidth, label, message, color, labelWidth/2, labelWidth+messageWidth/2)
+}
+
+// badgeTextWidth estimates the width of the text in Verdana 11px
+func badgeTextWidth(text string) int {
+	width := 0
+	for _, c := range text {
+		switch {
+		case strings.ContainsRune("fijlrt.,:;!|' ", c):
+			width += 4
+		case strings.ContainsRune("mwMW", c):
+			width += 10
+		case c >= 'A' && c <= 'Z':
+			width += 8
+		default:
+			width += 7
+		}
+	}
+	return width
+}
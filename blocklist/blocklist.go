@@ -0,0 +1,37 @@
+package blocklist
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Blocklist contains the parts of repository names not allowed to be built
+// or listed
+type Blocklist struct {
+	Blocked []struct {
+		NamePart string `yaml:"name"`
+		Reason   string `yaml:"reason"`
+	} `yaml:"blocked"`
+}
+
+// LoadFromFile reads the list from a YAML file
+func (r *Blocklist) LoadFromFile(filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(content, r)
+}
+
+// IsBlocked reports whether the repository is blocked and why
+func (r *Blocklist) IsBlocked(repo string) (bool, string) {
+	for _, blocked := range r.Blocked {
+		if strings.Contains(repo, blocked.NamePart) {
+			return true, blocked.Reason
+		}
+	}
+	return false, ""
+}
@@ -6,10 +6,38 @@ function log {
   echo "[$(date +%H:%M:%S.%N)] $@"
 }
 
+# Secrets from the env section of the .gobuilder.yml are handed over in
+# /input/secret_env_<phase> as lines of NAME=<base64 value>. The file of a
+# phase is read and deleted when the phase starts and its values are only
+# exported during that phase.
+function load_secret_env {
+  if [ -f /input/secret_env_$1 ]; then
+    cat /input/secret_env_$1
+    rm -f /input/secret_env_$1
+  fi
+}
+
+function export_secret_env {
+  for entry in $1; do
+    export "${entry%%=*}=$(echo "${entry#*=}" | base64 -d)"
+  done
+}
+
+function unset_secret_env {
+  for entry in $1; do
+    unset "${entry%%=*}"
+  done
+}
+
 product=${REPO##*/}; product=${product%\.*}
 
-SIGNING=1
-cat /root/gpgkey.asc.enc | openssl enc -aes-256-cbc -a -d -k ${GPG_DECRYPT_KEY} | gpg --import 2>&1 1>/dev/null || SIGNING=0
+# Pull requests may come from untrusted forks and must never be signed
+# using the official key, the key is not even imported for them
+SIGNING=0
+if [ -z "${LABEL}" ] && [ -z "${PR_REF}" ]; then
+  SIGNING=1
+  cat /root/gpgkey.asc.enc | openssl enc -aes-256-cbc -a -d -k ${GPG_DECRYPT_KEY} | gpg --import 2>&1 1>/dev/null || SIGNING=0
+fi
 if [ ${SIGNING} -eq 1 ]; then
   echo "E2FF3D20865D6F9B6AE74ECB7D5420F913246261:6:" | gpg --import-ownertrust
 fi
@@ -20,12 +48,37 @@ export GOPATH=/go
 export GOPATH=${GOPATH}:/go/src/${REPO}/vendor
 export GOPATH=${GOPATH}:/go/src/${REPO}/Godeps/_workspace
 
+# Credentials for private repositories are handed over as files in /input
+# (not in the environment of the container) and are only available while
+# fetching
+if [ -f /input/fetch_ssh_key ]; then
+  log "Installing deploy key for private repository..."
+  cp /input/fetch_ssh_key /root/.ssh/id_fetch
+  rm -f /input/fetch_ssh_key
+  chmod 600 /root/.ssh/id_fetch
+  export GIT_SSH_COMMAND="ssh -i /root/.ssh/id_fetch"
+  git config --global url."git@${REPO%%/*}:".insteadOf "https://${REPO%%/*}/"
+fi
+if [ -f /input/fetch_token ]; then
+  log "Installing access token for private repository..."
+  git config --global url."https://x-access-token:$(cat /input/fetch_token)@${REPO%%/*}/".insteadOf "https://${REPO%%/*}/"
+  rm -f /input/fetch_token
+fi
+
 log "Fetching missing code for GO repository ${REPO}"
 gopath=${REPO}
+secret_env_fetch=$(load_secret_env fetch)
+export_secret_env "${secret_env_fetch}"
 go get -d -v ${REPO}
 
 cd /go/src/${gopath}
 
+if [ ! -z ${PR_REF} ]; then
+  log "Fetching pull request head ${PR_REF}..."
+  git fetch origin ${PR_REF}
+  [ -z ${COMMIT} ] && git checkout FETCH_HEAD
+fi
+
 if [ ! -z ${COMMIT} ]; then
   log "Checking out forced commit ${COMMIT}..."
   git checkout ${COMMIT}
@@ -36,13 +89,34 @@ fi
 # Fetch all refs from origin for tag / branch detection
 git fetch origin
 
+# Remove credentials for private repositories before running foreign code
+rm -f /root/.ssh/id_fetch
+unset GIT_SSH_COMMAND
+rm -f /root/.gitconfig
+unset_secret_env "${secret_env_fetch}"
+unset secret_env_fetch
+
 short_commit=$(git rev-parse --short HEAD)
 tags=$(git show-ref --tags -d | grep "^${short_commit}" | sed -e 's,.* refs/tags/,,' -e 's/\^{}//')
 branches=$(git show-ref -d --heads | grep "^${short_commit}" | sed -e 's,.* refs/heads/,,')
 
+if [ ! -z ${LABEL} ]; then
+  # Isolated builds (pull requests) only store assets under their own label
+  log "Building isolated label ${LABEL}..."
+  tags=""
+  branches=${LABEL}
+  FORCE_BUILD=true
+fi
+
 mkdir -p /tmp/go-build
-wget -qO /tmp/go-build/.build_commit "https://gobuilder.me/api/v1/${gopath}/already-built?commit=${short_commit}" || touch /tmp/go-build/.build_commit
-wget -qO /tmp/go-build/.build.db https://gobuilder.me/api/v1/${gopath}/build.db || bash -c 'echo "{}" > /tmp/go-build/.build.db'
+
+# Branches and tags of the repository are used to clean up labels of removed branches
+git for-each-ref --format='%(refname)' refs/remotes/origin refs/tags | \
+  sed -e 's,^refs/remotes/origin/,branch ,' -e 's,^refs/tags/,tag ,' | \
+  grep -v '^branch HEAD$' > /tmp/go-build/.known_refs || true
+# The commits already built and the build.db are handed over by the starter
+# as the API does not expose them for private repositories
+cp /input/build.db /tmp/go-build/.build.db 2>/dev/null || echo "{}" > /tmp/go-build/.build.db
 
 if [ ! -f .gobuilder.yml ]; then
   # Ensure .gobuilder.yml is present to prevent tools failing later
@@ -63,7 +137,7 @@ if [ "$(configreader read no_go_fmt)" != "true" ]; then
 fi
 
 if ! ( test "${FORCE_BUILD}" == "true" ); then
-  if [ "$(cat /tmp/go-build/.build_commit)" == "${short_commit}" ]; then
+  if grep -qx "${short_commit}" /input/built_commits 2>/dev/null; then
     log "Commit ${short_commit} was already built. Skipping."
     exit 130
   fi
@@ -102,6 +176,9 @@ log "Collecting build matrix..."
 platforms=$(configreader read arch_matrix)
 echo ${platforms}
 
+secret_env_build=$(load_secret_env build)
+export_secret_env "${secret_env_build}"
+
 for platform in ${platforms}; do
   export GOOS=${platform%/*}
   export GOARCH=${platform##*/}
@@ -144,6 +221,9 @@ for platform in ${platforms}; do
   rm -rf /tmp/go-build/${product}/
 done
 
+unset_secret_env "${secret_env_build}"
+unset secret_env_build
+
 log "Checking README-File..."
 if ! ( configreader checkEmpty readme_file ) && [ -f "$(configreader read readme_file)" ]; then
   cp "$(configreader read readme_file)" /tmp/go-build/${short_commit}_README.md
@@ -195,7 +275,8 @@ echo ${short_commit} > /tmp/go-build/.build_commit
 go version > /tmp/go-build/.goversion
 
 log "Removing temporary build artifacts..."
-rm -f /tmp/go-build/${short_commit}_README.md /tmp/go-build/${product}_${short_commit}_*
+# Zips named by the commit are kept as immutable copies for the build history
+rm -f /tmp/go-build/${short_commit}_README.md
 
 log "Uploading assets..."
 rsync -arv /tmp/go-build/ /artifacts/
@@ -0,0 +1,56 @@
+package buildconfig
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// This block contains the phases of the build environment variables can
+// be made available in
+const (
+	EnvPhaseFetch = "fetch"
+	EnvPhaseBuild = "build"
+)
+
+var (
+	envNameRegex     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	reservedEnvNames = []string{
+		"REPO", "COMMIT", "LABEL", "PR_REF", "FORCE_BUILD", "GPG_DECRYPT_KEY",
+		"GOPATH", "GOROOT", "GOOS", "GOARCH", "CGO_ENABLED", "PATH", "HOME",
+	}
+)
+
+// EnvEntry describes an environment variable passed into the build
+// container. The Value may be encrypted using the gobuilder-cli.
+type EnvEntry struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+	// Phase can be one of "fetch" (while fetching the code) or "build"
+	// (while compiling the code, default)
+	Phase string `yaml:"phase,omitempty"`
+}
+
+// GetPhase returns the configured phase or the default phase
+func (e EnvEntry) GetPhase() string {
+	if e.Phase == "" {
+		return EnvPhaseBuild
+	}
+	return e.Phase
+}
+
+func validateEnv(env []EnvEntry) error {
+	for i, e := range env {
+		if !envNameRegex.MatchString(e.Name) {
+			return fmt.Errorf("Environment variable #%d has an invalid name %q", i+1, e.Name)
+		}
+		for _, reserved := range reservedEnvNames {
+			if e.Name == reserved {
+				return fmt.Errorf("Environment variable %s is reserved by GoBuilder", e.Name)
+			}
+		}
+		if e.GetPhase() != EnvPhaseFetch && e.GetPhase() != EnvPhaseBuild {
+			return fmt.Errorf("Environment variable %s has unknown phase %q", e.Name, e.Phase)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package buildconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy describes which builds and labels of a repository are
+// kept in the artifact store. Unset fields fall back to the global policy.
+type RetentionPolicy struct {
+	// KeepBuilds is the number of builds kept in the history of every
+	// branch label, 0 keeps all builds
+	KeepBuilds int `yaml:"keep_builds,omitempty" json:"keep_builds,omitempty"`
+	// KeepTags protects all builds of tags from being removed
+	KeepTags *bool `yaml:"keep_tags,omitempty" json:"keep_tags,omitempty"`
+	// DeleteRemovedBranches removes labels whose branch does no longer exist
+	DeleteRemovedBranches *bool `yaml:"delete_removed_branches,omitempty" json:"delete_removed_branches,omitempty"`
+	// MaxAge removes builds older than the given age ("720h" or "30d")
+	MaxAge string `yaml:"max_age,omitempty" json:"max_age,omitempty"`
+}
+
+// Merge returns a policy using the values of the override where they are
+// set and the values of the policy itself otherwise
+func (r RetentionPolicy) Merge(override *RetentionPolicy) RetentionPolicy {
+	if override == nil {
+		return r
+	}
+
+	if override.KeepBuilds > 0 {
+		r.KeepBuilds = override.KeepBuilds
+	}
+	if override.KeepTags != nil {
+		r.KeepTags = override.KeepTags
+	}
+	if override.DeleteRemovedBranches != nil {
+		r.DeleteRemovedBranches = override.DeleteRemovedBranches
+	}
+	if override.MaxAge != "" {
+		r.MaxAge = override.MaxAge
+	}
+
+	return r
+}
+
+// ShouldKeepTags reports whether builds of tags are protected (default)
+func (r RetentionPolicy) ShouldKeepTags() bool {
+	return r.KeepTags == nil || *r.KeepTags
+}
+
+// ShouldDeleteRemovedBranches reports whether labels of removed branches
+// are deleted (default)
+func (r RetentionPolicy) ShouldDeleteRemovedBranches() bool {
+	return r.DeleteRemovedBranches == nil || *r.DeleteRemovedBranches
+}
+
+// MaxAgeDuration parses the MaxAge and returns 0 if no maximum age is set
+func (r RetentionPolicy) MaxAgeDuration() (time.Duration, error) {
+	if r.MaxAge == "" {
+		return 0, nil
+	}
+
+	if strings.HasSuffix(r.MaxAge, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(r.MaxAge, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("Invalid max_age %q", r.MaxAge)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(r.MaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid max_age %q", r.MaxAge)
+	}
+	return d, nil
+}
+
+// Validate checks the values of the policy
+func (r *RetentionPolicy) Validate() error {
+	if r == nil {
+		return nil
+	}
+
+	if r.KeepBuilds < 0 {
+		return fmt.Errorf("retention: keep_builds must not be negative")
+	}
+
+	d, err := r.MaxAgeDuration()
+	if err != nil {
+		return fmt.Errorf("retention: %s", err)
+	}
+	if d < 0 {
+		return fmt.Errorf("retention: max_age must not be negative")
+	}
+
+	return nil
+}
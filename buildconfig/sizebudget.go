@@ -0,0 +1,123 @@
+package buildconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Actions taken when a binary exceeds the size budget
+const (
+	SizeBudgetWarn = "warn"
+	SizeBudgetFail = "fail"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"GB", 1000 * 1000 * 1000}, {"MB", 1000 * 1000}, {"KB", 1000},
+	{"B", 1},
+}
+
+// SizeBudget limits the size of the uncompressed binaries of a build
+type SizeBudget struct {
+	// MaxSize is the maximum size of every binary ("15MB", "20MiB")
+	MaxSize string `yaml:"max_size,omitempty"`
+	// MaxGrowth is the maximum growth of a binary in percent compared to
+	// the previous build of the label
+	MaxGrowth float64 `yaml:"max_growth,omitempty"`
+	// Action is either "warn" (default) to notify about the violation or
+	// "fail" to mark the build as failed without publishing its assets
+	Action string `yaml:"action,omitempty"`
+}
+
+// ShouldFail reports whether a violation of the budget fails the build
+func (s SizeBudget) ShouldFail() bool {
+	return s.Action == SizeBudgetFail
+}
+
+// MaxBytes returns the MaxSize in bytes or 0 if no maximum size is set
+func (s SizeBudget) MaxBytes() (int64, error) {
+	if s.MaxSize == "" {
+		return 0, nil
+	}
+	return ParseSize(s.MaxSize)
+}
+
+// Check compares the size of a binary to the budget and to the size of
+// the same binary in the previous build (0 if unknown) and describes the
+// violations found
+func (s SizeBudget) Check(size, previous int64) ([]string, error) {
+	violations := []string{}
+
+	max, err := s.MaxBytes()
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && size > max {
+		violations = append(violations, fmt.Sprintf("%s exceeds the maximum size of %s", FormatSize(size), s.MaxSize))
+	}
+
+	if s.MaxGrowth > 0 && previous > 0 {
+		growth := float64(size-previous) / float64(previous) * 100
+		if growth > s.MaxGrowth {
+			violations = append(violations, fmt.Sprintf("grew by %.1f%% (%s to %s), more than %.1f%%", growth, FormatSize(previous), FormatSize(size), s.MaxGrowth))
+		}
+	}
+
+	return violations, nil
+}
+
+// Validate checks the values of the budget
+func (s *SizeBudget) Validate() error {
+	if s == nil {
+		return nil
+	}
+
+	if _, err := s.MaxBytes(); err != nil {
+		return fmt.Errorf("size_budget: %s", err)
+	}
+	if s.MaxGrowth < 0 {
+		return fmt.Errorf("size_budget: max_growth must not be negative")
+	}
+	if s.Action != "" && s.Action != SizeBudgetWarn && s.Action != SizeBudgetFail {
+		return fmt.Errorf("size_budget: action must be %q or %q", SizeBudgetWarn, SizeBudgetFail)
+	}
+
+	return nil
+}
+
+// ParseSize reads sizes like "15MB", "20MiB" or "1048576" into bytes
+func ParseSize(size string) (int64, error) {
+	in := strings.TrimSpace(size)
+
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(in, u.suffix) {
+			in = strings.TrimSpace(strings.TrimSuffix(in, u.suffix))
+			factor = u.factor
+			break
+		}
+	}
+
+	v, err := strconv.ParseFloat(in, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("Invalid size %q", size)
+	}
+	return int64(v * float64(factor)), nil
+}
+
+// FormatSize returns a human readable representation of the size
+func FormatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
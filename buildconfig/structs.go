@@ -18,6 +18,9 @@ type BuildConfig struct {
 	BuildMatrix map[string]ArchConfig        `yaml:"build_matrix,omitempty"`
 	NoGoFmt     string                       `yaml:"no_go_fmt,omitempty"`
 	AllowCGO    string                       `yaml:"allow_cgo,omitempty"`
+	Env         []EnvEntry                   `yaml:"env,omitempty"`
+	Retention   *RetentionPolicy             `yaml:"retention,omitempty"`
+	SizeBudget  *SizeBudget                  `yaml:"size_budget,omitempty"`
 }
 
 type buildConfigV0 struct {
@@ -45,15 +48,35 @@ func LoadFromFile(filepath string) (*BuildConfig, error) {
 		return nil, err
 	}
 
+	return Parse(buf)
+}
+
+// Parse reads the BuildConfig from the contents of a .gobuilder.yml file
+// and transforms it into the latest config version if required
+func Parse(buf []byte) (*BuildConfig, error) {
 	for {
 		tmp := BuildConfig{}
 		if err := yaml.Unmarshal(buf, &tmp); err == nil {
+			if err := tmp.Notify.Validate(); err != nil {
+				return nil, err
+			}
+			if err := validateEnv(tmp.Env); err != nil {
+				return nil, err
+			}
+			if err := tmp.Retention.Validate(); err != nil {
+				return nil, err
+			}
+			if err := tmp.SizeBudget.Validate(); err != nil {
+				return nil, err
+			}
 			return &tmp, nil
 		}
 
 		tmp0 := buildConfigV0{}
 		if err := yaml.Unmarshal(buf, &tmp0); err == nil {
-			buf, err = upgradeConfigV0(tmp0)
+			if buf, err = upgradeConfigV0(tmp0); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
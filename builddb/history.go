@@ -0,0 +1,192 @@
+package builddb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuyu/goredis"
+)
+
+// LegacyBuildID is the ID of build records migrated from the BuildDB which
+// did not know about the build producing the assets
+const LegacyBuildID = "legacy"
+
+// UnloggedBuildID is the prefix of the IDs of builds whose build log could
+// not be stored. Other builds are identified by the ID of their build log.
+const UnloggedBuildID = "unlogged"
+
+// Build is the record of a single build of a label. Every rebuild of a
+// label creates a new record so the assets of former builds stay known.
+type Build struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label"`
+	Commit    string    `json:"commit"`
+	GoVersion string    `json:"go_version"`
+	BuildDate time.Time `json:"build_date"`
+	Assets    []Asset   `json:"assets"`
+}
+
+// Branch converts the build record into the BuildDB representation
+func (b Build) Branch() Branch {
+	return Branch{
+		GoVersion: b.GoVersion,
+		BuildDate: b.BuildDate,
+		Assets:    b.Assets,
+	}
+}
+
+// HasLog reports whether the build log of the build is stored under the
+// ID of the build
+func (b Build) HasLog() bool {
+	return b.ID != "" && !strings.HasPrefix(b.ID, LegacyBuildID) && !strings.HasPrefix(b.ID, UnloggedBuildID)
+}
+
+func historyKey(repo, label string) string {
+	return fmt.Sprintf("project::%s::history::%s", repo, label)
+}
+
+func buildKey(repo, label, id string) string {
+	return fmt.Sprintf("%s::%s", historyKey(repo, label), id)
+}
+
+func currentKey(repo string) string {
+	return fmt.Sprintf("project::%s::current-builds", repo)
+}
+
+// AddBuild stores the record in the history of its label and makes it the
+// current build of the label
+func AddBuild(redisClient *goredis.Redis, repo string, b Build) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	if err := redisClient.Set(buildKey(repo, b.Label, b.ID), string(data), 0, 0, false, false); err != nil {
+		return err
+	}
+
+	if _, err := redisClient.ZAdd(historyKey(repo, b.Label), map[string]float64{
+		b.ID: float64(b.BuildDate.Unix()),
+	}); err != nil {
+		return err
+	}
+
+	_, err = redisClient.HSet(currentKey(repo), b.Label, b.ID)
+	return err
+}
+
+// GetBuild returns the record of the build of the label or nil if there
+// is no such build
+func GetBuild(redisClient *goredis.Redis, repo, label, id string) (*Build, error) {
+	data, err := redisClient.Get(buildKey(repo, label, id))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	b := &Build{}
+	return b, json.Unmarshal(data, b)
+}
+
+// CurrentBuild returns the record of the build currently published for
+// the label or nil if the label has no history
+func CurrentBuild(redisClient *goredis.Redis, repo, label string) (*Build, error) {
+	id, err := redisClient.HGet(currentKey(repo), label)
+	if err != nil || id == nil {
+		return nil, err
+	}
+
+	return GetBuild(redisClient, repo, label, string(id))
+}
+
+// HistoryLength returns the number of builds recorded for the label
+func HistoryLength(redisClient *goredis.Redis, repo, label string) (int, error) {
+	n, err := redisClient.ZCard(historyKey(repo, label))
+	return int(n), err
+}
+
+// History lists the builds of the label newest first. Start and stop are
+// zero-based and inclusive like the Redis ranges.
+func History(redisClient *goredis.Redis, repo, label string, start, stop int) ([]Build, error) {
+	ids, err := redisClient.ZRevRange(historyKey(repo, label), start, stop, false)
+	if err != nil {
+		return nil, err
+	}
+
+	builds := []Build{}
+	for _, id := range ids {
+		b, err := GetBuild(redisClient, repo, label, id)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			builds = append(builds, *b)
+		}
+	}
+
+	return builds, nil
+}
+
+// RemoveBuild drops a single build from the history of the label
+func RemoveBuild(redisClient *goredis.Redis, repo, label, id string) error {
+	if _, err := redisClient.ZRem(historyKey(repo, label), id); err != nil {
+		return err
+	}
+
+	_, err := redisClient.Del(buildKey(repo, label, id))
+	return err
+}
+
+// RemoveLabel drops the history and the current pointer of the label
+func RemoveLabel(redisClient *goredis.Redis, repo, label string) error {
+	ids, err := redisClient.ZRange(historyKey(repo, label), 0, -1, false)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{historyKey(repo, label)}
+	for _, id := range ids {
+		keys = append(keys, buildKey(repo, label, id))
+	}
+
+	if _, err := redisClient.Del(keys...); err != nil {
+		return err
+	}
+
+	_, err = redisClient.HDel(currentKey(repo), label)
+	return err
+}
+
+// Migrate creates legacy build records for labels of the BuildDB not yet
+// having any history. Labels passed in skip are ignored.
+func Migrate(redisClient *goredis.Redis, repo string, db BuildDB, skip ...string) error {
+	current, err := redisClient.HGetAll(currentKey(repo))
+	if err != nil {
+		return err
+	}
+
+	skipped := map[string]bool{}
+	for _, s := range skip {
+		skipped[s] = true
+	}
+
+	for label, branch := range db {
+		if _, ok := current[label]; ok || skipped[label] {
+			continue
+		}
+
+		if err := AddBuild(redisClient, repo, Build{
+			ID:        LegacyBuildID + "-" + strconv.FormatInt(branch.BuildDate.Unix(), 10),
+			Label:     label,
+			GoVersion: branch.GoVersion,
+			BuildDate: branch.BuildDate,
+			Assets:    branch.Assets,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,15 @@
 package builddb
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PullRequestLabelPrefix is the prefix of ephemeral labels created for
+// pull request builds
+const PullRequestLabelPrefix = "pr-"
 
 // The BuildDB is an archive for former builds
 type BuildDB map[string]Branch
@@ -12,6 +21,22 @@ type Branch struct {
 	Assets    []Asset   `json:"assets"`
 }
 
+// PullRequestLabel returns the ephemeral label used to store the assets
+// of the given pull request
+func PullRequestLabel(number int) string {
+	return fmt.Sprintf("%s%d", PullRequestLabelPrefix, number)
+}
+
+// IsPullRequestLabel reports whether the label belongs to a pull request
+// build and therefore is hidden from the default label list
+func IsPullRequestLabel(label string) bool {
+	if !strings.HasPrefix(label, PullRequestLabelPrefix) {
+		return false
+	}
+	_, err := strconv.Atoi(strings.TrimPrefix(label, PullRequestLabelPrefix))
+	return err == nil
+}
+
 // Asset contains information about the archive files in a BuildDBBranch
 type Asset struct {
 	SHA1     string `json:"sha1"`
@@ -19,6 +44,11 @@ type Asset struct {
 	MD5      string `json:"md5"`
 	Size     int64  `json:"size"`
 	FileName string `json:"file_name"`
+	// BinarySize is the size of the uncompressed binary inside the zip
+	BinarySize int64 `json:"binary_size,omitempty"`
+	// Path is the storage key of an immutable copy of the asset which is
+	// not overwritten by later builds of the label
+	Path string `json:"path,omitempty"`
 }
 
 // ByFilename implements a sorter for Assets
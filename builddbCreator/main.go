@@ -35,6 +35,10 @@ func GenerateBuildDB(basedir string) error {
 		return err
 	}
 
+	// Zips named by the commit are kept as immutable copies for the build
+	// history and must not show up as a label
+	buildCommit, _ := ioutil.ReadFile(fmt.Sprintf("%s/.build_commit", basedir))
+
 	cache := make(map[string]map[string]os.FileInfo)
 	files, _ := ioutil.ReadDir(fmt.Sprintf("%s/", basedir))
 
@@ -42,6 +46,9 @@ func GenerateBuildDB(basedir string) error {
 		if strings.HasSuffix(f.Name(), ".zip") {
 			tmp := strings.Split(f.Name(), "_")
 			buildName := tmp[len(tmp)-2]
+			if buildName == strings.TrimSpace(string(buildCommit)) {
+				continue
+			}
 
 			if _, ok := cache[buildName]; !ok {
 				cache[buildName] = make(map[string]os.FileInfo)
@@ -61,11 +68,12 @@ func GenerateBuildDB(basedir string) error {
 		for _, f := range fileNames {
 			md5sum, sha1sum, sha256sum := buildHashes(fmt.Sprintf("%s/%s", basedir, f.Name()))
 			tmp.Assets = append(tmp.Assets, builddb.Asset{
-				Size:     f.Size(),
-				SHA1:     sha1sum,
-				SHA256:   sha256sum,
-				MD5:      md5sum,
-				FileName: f.Name(),
+				Size:       f.Size(),
+				SHA1:       sha1sum,
+				SHA256:     sha256sum,
+				MD5:        md5sum,
+				FileName:   f.Name(),
+				BinarySize: BinarySize(basedir, f.Name()),
 			})
 		}
 
@@ -80,6 +88,18 @@ func GenerateBuildDB(basedir string) error {
 	return nil
 }
 
+// BinarySize returns the size of the uncompressed binary stored next to
+// the zip or 0 if there is no such binary
+func BinarySize(basedir, zipName string) int64 {
+	binary := fmt.Sprintf("%s/%s", basedir, strings.TrimSuffix(zipName, ".zip"))
+	for _, name := range []string{binary, binary + ".exe"} {
+		if info, err := os.Stat(name); err == nil {
+			return info.Size()
+		}
+	}
+	return 0
+}
+
 func buildHashes(filename string) (string, string, string) {
 	fileContent, _ := ioutil.ReadFile(filename)
 	sha1sum := fmt.Sprintf("%x", sha1.Sum(fileContent))
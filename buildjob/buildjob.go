@@ -11,6 +11,25 @@ type BuildJob struct {
 	Repository         string
 	Commit             string
 	NumberOfExecutions int
+	// Force disables the check whether the commit was already built
+	Force bool
+
+	// Isolated builds (pull requests) carry the label to store their
+	// assets under and the ref to fetch the code from
+	Label       string
+	Ref         string
+	PullRequest int
+
+	// Assets of a finished build whose upload failed are kept by the
+	// starter on UploadHost to resume the upload without rebuilding
+	UploadDir  string
+	UploadHost string
+}
+
+// IsIsolated reports whether the job builds a pull request whose assets
+// must not interfere with the regular labels of the repository
+func (b *BuildJob) IsIsolated() bool {
+	return b.Label != ""
 }
 
 // ToByte creats a gob encoded version of the BuildJob to store in text
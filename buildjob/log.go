@@ -4,14 +4,92 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/gob"
+	"regexp"
+	"strings"
 	"time"
 )
 
+var (
+	stepMarkerRegex     = regexp.MustCompile(`^\[(\d{2}:\d{2}:\d{2}(?:\.\d+)?)\] (.*)$`)
+	platformStartRegex  = regexp.MustCompile(`^Building .* for ([a-z0-9]+-[a-z0-9]+)\.\.\.$`)
+	platformFailedRegex = regexp.MustCompile(`^Build for ([a-z0-9]+-[a-z0-9]+) failed\.$`)
+)
+
 // BuildLog is a metadata blob to hold state about a log
 type BuildLog struct {
-	ID      string
-	Success bool
-	Time    time.Time
+	ID        string
+	Success   bool
+	Time      time.Time
+	Steps     []BuildStep
+	Platforms []PlatformResult
+	// Commit is the built commit, empty if the build failed before the
+	// commit was known
+	Commit string
+}
+
+// BuildStep is a section of the build log started by a step marker
+// printed by the build script
+type BuildStep struct {
+	Name     string
+	Line     int
+	Offset   time.Duration
+	Duration time.Duration
+}
+
+// PlatformResult contains whether the build for a single platform of the
+// build matrix succeeded
+type PlatformResult struct {
+	Platform string
+	Success  bool
+}
+
+// ParseTimeline extracts the steps and the results of the platform builds
+// from the step markers ("[15:04:05.000000000] message") in the build log
+func ParseTimeline(buildLog string) ([]BuildStep, []PlatformResult) {
+	steps := []BuildStep{}
+	platforms := []PlatformResult{}
+	platformIndex := map[string]int{}
+
+	var first, last time.Time
+	for i, line := range strings.Split(buildLog, "\n") {
+		m := stepMarkerRegex.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+
+		t, err := time.Parse("15:04:05.999999999", m[1])
+		if err != nil {
+			continue
+		}
+		if len(steps) == 0 {
+			first = t
+		} else {
+			for t.Before(last) {
+				// The build was running over midnight
+				t = t.Add(24 * time.Hour)
+			}
+			steps[len(steps)-1].Duration = t.Sub(last)
+		}
+		last = t
+
+		steps = append(steps, BuildStep{
+			Name:   m[2],
+			Line:   i + 1,
+			Offset: t.Sub(first),
+		})
+
+		if p := platformStartRegex.FindStringSubmatch(m[2]); p != nil {
+			platformIndex[p[1]] = len(platforms)
+			platforms = append(platforms, PlatformResult{Platform: p[1], Success: true})
+		}
+		if p := platformFailedRegex.FindStringSubmatch(m[2]); p != nil {
+			if idx, ok := platformIndex[p[1]]; ok {
+				platforms[idx].Success = false
+			}
+		}
+	}
+
+	return steps, platforms
 }
 
 // ToString creats a gob encoded version of the BuildJob to store in text
@@ -0,0 +1,103 @@
+package buildjob
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTimeline(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		log       string
+		steps     []BuildStep
+		platforms []PlatformResult
+	}{
+		{
+			name:      "empty log",
+			log:       "",
+			steps:     []BuildStep{},
+			platforms: []PlatformResult{},
+		},
+		{
+			name:      "log without timestamps",
+			log:       "go: downloading github.com/foo/bar v1.0.0\nok\n",
+			steps:     []BuildStep{},
+			platforms: []PlatformResult{},
+		},
+		{
+			name: "steps",
+			log: "[12:00:00.000000000] Fetching sources\n" +
+				"Cloning into 'gobuilder'...\n" +
+				"[12:00:03.500000000] Building\r\n" +
+				"[12:01:03.500000000] Uploading assets\n",
+			steps: []BuildStep{
+				{Name: "Fetching sources", Line: 1, Offset: 0, Duration: 3500 * time.Millisecond},
+				{Name: "Building", Line: 3, Offset: 3500 * time.Millisecond, Duration: time.Minute},
+				{Name: "Uploading assets", Line: 4, Offset: time.Minute + 3500*time.Millisecond},
+			},
+			platforms: []PlatformResult{},
+		},
+		{
+			name: "timestamps without fraction",
+			log:  "[08:15:00] Start\n[08:15:10] End\n",
+			steps: []BuildStep{
+				{Name: "Start", Line: 1, Duration: 10 * time.Second},
+				{Name: "End", Line: 2, Offset: 10 * time.Second},
+			},
+			platforms: []PlatformResult{},
+		},
+		{
+			name: "midnight rollover",
+			log: "[23:59:50.000000000] Fetching sources\n" +
+				"[00:00:05.000000000] Building\n" +
+				"[00:01:05.000000000] Uploading assets\n",
+			steps: []BuildStep{
+				{Name: "Fetching sources", Line: 1, Duration: 15 * time.Second},
+				{Name: "Building", Line: 2, Offset: 15 * time.Second, Duration: time.Minute},
+				{Name: "Uploading assets", Line: 3, Offset: 75 * time.Second},
+			},
+			platforms: []PlatformResult{},
+		},
+		{
+			name: "malformed lines",
+			log: "[12:00:00.000000000] Start\n" +
+				"[25:61:00.000000000] Invalid time\n" +
+				"[12:00] Short time\n" +
+				"[12:00:01.000000000]Missing space\n" +
+				" [12:00:01.000000000] Indented\n" +
+				"[12:00:01.000000000]\n" +
+				"[12:00:02.000000000] End\n",
+			steps: []BuildStep{
+				{Name: "Start", Line: 1, Duration: 2 * time.Second},
+				{Name: "End", Line: 7, Offset: 2 * time.Second},
+			},
+			platforms: []PlatformResult{},
+		},
+		{
+			name: "platforms",
+			log: "[12:00:00.000000000] Building gobuilder for linux-amd64...\n" +
+				"[12:00:10.000000000] Building gobuilder for windows-386...\n" +
+				"[12:00:20.000000000] Build for windows-386 failed.\n" +
+				"[12:00:21.000000000] Build for darwin-arm64 failed.\n",
+			steps: []BuildStep{
+				{Name: "Building gobuilder for linux-amd64...", Line: 1, Duration: 10 * time.Second},
+				{Name: "Building gobuilder for windows-386...", Line: 2, Offset: 10 * time.Second, Duration: 10 * time.Second},
+				{Name: "Build for windows-386 failed.", Line: 3, Offset: 20 * time.Second, Duration: time.Second},
+				{Name: "Build for darwin-arm64 failed.", Line: 4, Offset: 21 * time.Second},
+			},
+			platforms: []PlatformResult{
+				{Platform: "linux-amd64", Success: true},
+				{Platform: "windows-386", Success: false},
+			},
+		},
+	} {
+		steps, platforms := ParseTimeline(c.log)
+		if !reflect.DeepEqual(steps, c.steps) {
+			t.Errorf("%s: steps = %+v, expected %+v", c.name, steps, c.steps)
+		}
+		if !reflect.DeepEqual(platforms, c.platforms) {
+			t.Errorf("%s: platforms = %+v, expected %+v", c.name, platforms, c.platforms)
+		}
+	}
+}
@@ -1,44 +1,144 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 
+	"github.com/Luzifer/gobuilder/ansi"
+	"github.com/Luzifer/gobuilder/buildjob"
+	"github.com/Luzifer/gobuilder/logstore"
+	"github.com/Luzifer/gobuilder/notifier"
+	"github.com/Sirupsen/logrus"
 	"github.com/flosch/pongo2"
 	"github.com/gorilla/mux"
 )
 
 func handlerBuildLog(res http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	file, err := redisClient.Get(fmt.Sprintf("project::%s::logs::%s", params["repo"], params["logid"]))
-	if err != nil {
+	if !checkRepoAccess(res, r, params["repo"]) {
+		return
+	}
+
+	file, err := logstore.Read(redisClient, params["repo"], params["logid"])
+	if err != nil || file == nil {
 		file = []byte("No build log was found for this build.")
 	}
 
+	deliveries := []notifier.Delivery{}
+	rawDeliveries, _ := redisClient.LRange(fmt.Sprintf("project::%s::logs::%s::notifications", params["repo"], params["logid"]), 0, -1)
+	for _, raw := range rawDeliveries {
+		if d, err := notifier.DeliveryFromString(raw); err == nil {
+			deliveries = append(deliveries, *d)
+		}
+	}
+
 	template := pongo2.Must(pongo2.FromFile("frontend/buildlog.html"))
 	ctx := getBasicContext(res, r)
 	ctx["repo"] = params["repo"]
 	ctx["log"] = logHighlight(file)
+	ctx["deliveries"] = deliveries
+	ctx["meta"] = getBuildLogMeta(params["repo"], params["logid"])
+	ctx["logid"] = params["logid"]
 
 	template.ExecuteWriter(ctx, res)
 
 }
 
+func handlerBuildLogText(res http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !checkRepoAccess(res, r, params["repo"]) {
+		return
+	}
+
+	file, err := logstore.Read(redisClient, params["repo"], params["logid"])
+	if err != nil || file == nil {
+		http.Error(res, "Not found", http.StatusNotFound)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.FormValue("ansi") != "true" {
+		file = []byte(ansi.Strip(string(file)))
+	}
+	res.Write(file)
+}
+
+type buildLogExport struct {
+	Repository string             `json:"repository"`
+	ID         string             `json:"id"`
+	Meta       *buildjob.BuildLog `json:"meta"`
+	Log        string             `json:"log"`
+}
+
+func handlerBuildLogJSON(res http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !checkRepoAccess(res, r, params["repo"]) {
+		return
+	}
+
+	file, err := logstore.Read(redisClient, params["repo"], params["logid"])
+	if err != nil || file == nil {
+		http.Error(res, "Not found", http.StatusNotFound)
+		return
+	}
+
+	out := buildLogExport{
+		Repository: params["repo"],
+		ID:         params["logid"],
+		Meta:       getBuildLogMeta(params["repo"], params["logid"]),
+		Log:        string(file),
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(out); err != nil {
+		log.WithFields(logrus.Fields{
+			"repo":  params["repo"],
+			"logid": params["logid"],
+			"err":   err,
+		}).Error("Unable to encode build log")
+	}
+}
+
+// getBuildLogMeta searches the metadata of the build log in the list of
+// logs of the repository and returns nil if it can't be found
+func getBuildLogMeta(repo, logID string) *buildjob.BuildLog {
+	logs, err := redisClient.ZRevRange(fmt.Sprintf("project::%s::logs", repo), 0, -1, false)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"repo": repo,
+			"err":  err,
+		}).Error("Unable to load logs")
+		return nil
+	}
+
+	for _, v := range logs {
+		if l, err := buildjob.LogFromString(v); err == nil && l.ID == logID {
+			return l
+		}
+	}
+
+	return nil
+}
+
 type logline struct {
 	Line         string
+	HTML         string
 	BuildComment bool
 }
 
 func logHighlight(log []byte) []logline {
 	lines := strings.Split(string(log), "\n")
 	highlightedLines := []logline{}
+	state := ansi.State{}
 	for _, line := range lines {
 		tmp := logline{
-			Line:         line,
+			Line:         ansi.Strip(line),
 			BuildComment: false,
 		}
-		if strings.HasPrefix(line, "[") {
+		tmp.HTML, state = ansi.ToHTML(line, state)
+		if strings.HasPrefix(tmp.Line, "[") {
 			tmp.BuildComment = true
 		}
 		highlightedLines = append(highlightedLines, tmp)
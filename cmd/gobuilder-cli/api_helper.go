@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+)
+
+// apiGet executes a GET request sending the stored API token
+func apiGet(u string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return apiDo(req)
+}
+
+// apiPostForm executes a form POST request sending the stored API token
+func apiPostForm(u string, data url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("POST", u, bytes.NewBufferString(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return apiDo(req)
+}
+
+func apiDo(req *http.Request) (*http.Response, error) {
+	if config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+config.Token)
+	}
+	return http.DefaultClient.Do(req)
+}
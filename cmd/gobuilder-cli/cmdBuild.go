@@ -21,7 +21,7 @@ func getBuildCommand() *cobra.Command {
 }
 
 func cmdBuild(cmd *cobra.Command, args []string) {
-	resp, err := http.PostForm("https://gobuilder.me/api/v1/webhook/cli", url.Values{
+	resp, err := apiPostForm("https://gobuilder.me/api/v1/webhook/cli", url.Values{
 		"repository": []string{config.Repo},
 	})
 	if err != nil {
@@ -37,6 +37,9 @@ func cmdBuild(cmd *cobra.Command, args []string) {
 	case http.StatusNotAcceptable:
 		fmt.Printf("GoBuilder rejected your repository. Please contact support at help@gobuilder.me")
 		os.Exit(1)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		fmt.Printf("Your API token was rejected. Please check it has the \"build\" scope.")
+		os.Exit(1)
 	case http.StatusInternalServerError:
 		fmt.Printf("Something went wrong on server side. Please try again.")
 		os.Exit(1)
@@ -42,7 +42,7 @@ func cmdEncrypt(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	resp, err := http.PostForm(fmt.Sprintf("https://gobuilder.me/api/v1/%s/encrypt", config.Repo), url.Values{
+	resp, err := apiPostForm(fmt.Sprintf("https://gobuilder.me/api/v1/%s/encrypt", config.Repo), url.Values{
 		"secret": []string{secret},
 	})
 	if err != nil {
@@ -52,6 +52,9 @@ func cmdEncrypt(cmd *cobra.Command, args []string) {
 	defer resp.Body.Close()
 
 	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		fmt.Printf("Only owners of the repository are allowed to encrypt secrets. Please log in using an API token with the \"admin\" scope.")
+		os.Exit(1)
 	case http.StatusInternalServerError:
 		fmt.Printf("Something went wrong on server side. Please try again.")
 		os.Exit(1)
@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"path"
 	"strings"
 
@@ -23,7 +22,7 @@ func (n noDownloadFoundError) Error() string { return "No download was found." }
 func (g generalDownloadError) Error() string { return g.Message }
 
 func downloadBuildResult(repo, version, search, target string) error {
-	resp, err := http.Get(fmt.Sprintf("https://gobuilder.me/api/v1/%s/hashes/%s.json", repo, version))
+	resp, err := apiGet(fmt.Sprintf("https://gobuilder.me/api/v1/%s/hashes/%s.json", repo, version))
 	if err != nil {
 		return generalDownloadError{"Was unable to communicate with GoBuilder, please try again."}
 	}
@@ -54,7 +53,7 @@ func downloadBuildResult(repo, version, search, target string) error {
 
 func downloadAndCheck(repo, filename, target string, hash builddb.Hashes) error {
 	url := fmt.Sprintf("https://gobuilder.me/get/%s/%s", repo, filename)
-	resp, err := http.Get(url)
+	resp, err := apiGet(url)
 	if err != nil {
 		fmt.Printf("An error ocurred while downloading the package '%s': %s", filename, err)
 		return err
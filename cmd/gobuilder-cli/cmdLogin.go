@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func getLoginCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "login [TOKEN]",
+		Short: "Store an API token created at https://gobuilder.me/tokens for further requests",
+		Run:   cmdLogin,
+	}
+
+	return cmd
+}
+
+func cmdLogin(cmd *cobra.Command, args []string) {
+	var token string
+	if len(args) == 1 {
+		token = args[0]
+	} else {
+		fmt.Printf("Please paste your API token: ")
+		t, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			fmt.Printf("Unable to read from STDIN")
+			os.Exit(1)
+		}
+		token = t
+	}
+
+	token = strings.TrimSpace(token)
+	if len(token) == 0 {
+		fmt.Printf("Please provide the token via argument or STDIN.")
+		os.Exit(1)
+	}
+
+	tokenFile := getTokenFile()
+	if err := os.MkdirAll(path.Dir(tokenFile), 0700); err != nil {
+		fmt.Printf("Unable to create config directory: %s", err)
+		os.Exit(1)
+	}
+
+	if err := ioutil.WriteFile(tokenFile, []byte(token), 0600); err != nil {
+		fmt.Printf("Unable to store token: %s", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Your token has been stored in %s\n", tokenFile)
+}
+
+func getTokenFile() string {
+	return path.Join(os.Getenv("HOME"), ".config", "gobuilder-cli", "token")
+}
+
+func loadToken() string {
+	token, err := ioutil.ReadFile(getTokenFile())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(token))
+}
@@ -12,6 +12,7 @@ import (
 
 var config = struct {
 	Repo  string
+	Token string
 	Debug bool
 }{
 	Debug: false,
@@ -19,6 +20,7 @@ var config = struct {
 
 func init() {
 	config.Repo = getRepo()
+	config.Token = loadToken()
 }
 
 func main() {
@@ -28,12 +30,14 @@ func main() {
 	}
 
 	app.PersistentFlags().StringVar(&config.Repo, "repo", config.Repo, "Repository to work with")
+	app.PersistentFlags().StringVar(&config.Token, "token", config.Token, "API token to authenticate with (defaults to the one stored by login)")
 
 	app.AddCommand(
 		getBuildCommand(),
 		getEncryptCommand(),
 		getGetCommand(),
 		getGetAllCommand(),
+		getLoginCommand(),
 	)
 
 	app.Execute()
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Luzifer/gobuilder/buildconfig"
+	"github.com/Luzifer/gobuilder/credentials"
+	"github.com/Luzifer/gobuilder/secrets"
+	"github.com/Sirupsen/logrus"
+)
+
+// LoadSecretEnv reads the env section of the .gobuilder.yml and writes
+// the decrypted values into one input file per phase. The build script
+// reads and deletes each file when its phase starts and exports the
+// values only during that phase.
+func (b *builder) LoadSecretEnv() error {
+	if b.job.IsIsolated() {
+		// Pull requests may contain foreign code which must not see secrets
+		return nil
+	}
+
+	env, err := b.fetchEnvConfig()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"host":  hostname,
+			"error": err,
+			"repo":  b.job.Repository,
+		}).Warn("Unable to fetch .gobuilder.yml, using env of last build")
+
+		raw, err := redisClient.Get(fmt.Sprintf("project::%s::env", b.job.Repository))
+		if err != nil {
+			return err
+		}
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &env); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(env) == 0 {
+		return nil
+	}
+
+	keyring, err := secrets.LoadKeyring(redisClient, b.job.Repository)
+	if err != nil {
+		return err
+	}
+
+	phases := map[string][]string{}
+	for _, e := range env {
+		value, err := keyring.Decrypt(e.Value)
+		if err != nil {
+			return fmt.Errorf("Unable to decrypt environment variable %s: %s", e.Name, err)
+		}
+
+		if secrets.IsEncrypted(e.Value) {
+			b.secretValues = append(b.secretValues, value)
+		}
+
+		// Values are encoded to safely transport multi-line values
+		phases[e.GetPhase()] = append(phases[e.GetPhase()],
+			fmt.Sprintf("%s=%s", e.Name, base64.StdEncoding.EncodeToString([]byte(value))))
+	}
+
+	for _, phase := range []string{buildconfig.EnvPhaseFetch, buildconfig.EnvPhaseBuild} {
+		if len(phases[phase]) == 0 {
+			continue
+		}
+		if err := b.writeInput(fmt.Sprintf("secret_env_%s", phase), strings.Join(phases[phase], "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StoreEnvConfig remembers the env section of the last build to be used
+// if the .gobuilder.yml can't be fetched before the next build
+func (b *builder) StoreEnvConfig() error {
+	key := fmt.Sprintf("project::%s::env", b.job.Repository)
+	if b.buildConfig == nil || len(b.buildConfig.Env) == 0 {
+		_, err := redisClient.Del(key)
+		return err
+	}
+
+	raw, err := json.Marshal(b.buildConfig.Env)
+	if err != nil {
+		return err
+	}
+	return redisClient.Set(key, string(raw), 0, 0, false, false)
+}
+
+// fetchEnvConfig reads the env section of the .gobuilder.yml of the
+// commit to build directly from the code hosting platform
+func (b *builder) fetchEnvConfig() ([]buildconfig.EnvEntry, error) {
+	sourceRepo := credentials.SourceRepository(b.job.Repository)
+	configPath := strings.TrimPrefix(strings.TrimPrefix(b.job.Repository, sourceRepo), "/")
+	if configPath != "" {
+		configPath += "/"
+	}
+	configPath += ".gobuilder.yml"
+
+	var req *http.Request
+	switch {
+	case strings.HasPrefix(sourceRepo, "github.com/"):
+		ref := b.job.Commit
+		if ref == "" {
+			ref = "HEAD"
+		}
+		req, _ = http.NewRequest("GET", fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s",
+			strings.TrimPrefix(sourceRepo, "github.com/"), ref, configPath), nil)
+		if b.fetchToken != "" {
+			req.Header.Set("Authorization", "token "+b.fetchToken)
+		}
+
+	case strings.HasPrefix(sourceRepo, gitlabHost()+"/"):
+		ref := b.job.Commit
+		if ref == "" {
+			ref = "master"
+		}
+		req, _ = http.NewRequest("GET", fmt.Sprintf("%s/api/v4/projects/%s/repository/files/%s/raw?ref=%s",
+			strings.TrimRight(conf.GitLab.URL, "/"),
+			url.QueryEscape(strings.TrimPrefix(sourceRepo, gitlabHost()+"/")),
+			url.QueryEscape(configPath),
+			url.QueryEscape(ref),
+		), nil)
+		if b.fetchToken != "" {
+			req.Header.Set("Authorization", "Bearer "+b.fetchToken)
+		}
+
+	default:
+		return nil, fmt.Errorf("Fetching files from %s is not supported", sourceRepo)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		if b.private && b.fetchToken == "" {
+			return nil, fmt.Errorf("Unable to access private repository")
+		}
+		// There is no .gobuilder.yml so there are no secrets
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := buildconfig.Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Env, nil
+}
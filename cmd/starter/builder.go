@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -13,12 +14,14 @@ import (
 	"strings"
 	"time"
 
-	"launchpad.net/goamz/s3"
-
 	"github.com/Luzifer/gobuilder/buildconfig"
+	"github.com/Luzifer/gobuilder/builddb"
 	"github.com/Luzifer/gobuilder/builddbCreator"
 	"github.com/Luzifer/gobuilder/buildjob"
+	"github.com/Luzifer/gobuilder/logstore"
 	"github.com/Luzifer/gobuilder/notifier"
+	"github.com/Luzifer/gobuilder/search"
+	"github.com/Luzifer/gobuilder/secrets"
 	"github.com/Sirupsen/logrus"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/satori/go.uuid"
@@ -38,15 +41,37 @@ type builder struct {
 
 	// Some remembered things to use in different calls
 	tmpDir         string
+	keepTmpDir     bool
 	buildStartTime time.Time
 	container      *docker.Container
 	buildConfig    *buildconfig.BuildConfig
 
+	// inputDir hands files like credentials to the build container
+	// without exposing them in its environment
+	inputDir string
+
 	// Details about the status of the build
 	BuildOK        bool
 	UploadRequired bool
 	BuildLog       string
 	AbortReason    string
+
+	// Private repositories are fetched using registered credentials and
+	// their assets are not publicly readable
+	private bool
+	// Access token of a private repository to fetch files through the API
+	fetchToken string
+
+	// Decrypted secrets passed into the build which must not be visible in
+	// the stored build log
+	secretValues []string
+
+	// Results of the build used to notify about it
+	buildLogID  string
+	builtCommit string
+	builtTags   []string
+	buildDB     builddb.BuildDB
+	warnings    []string
 }
 
 func newBuilder(job *buildjob.BuildJob) *builder {
@@ -121,6 +146,12 @@ func (b *builder) PrepareBuild(baseTmpDir string) error {
 	b.tmpDir = tmpDir
 	b.buildStartTime = time.Now()
 
+	inputDir, err := ioutil.TempDir(baseTmpDir, "gobuild-input")
+	if err != nil {
+		return err
+	}
+	b.inputDir = inputDir
+
 	b.UpdateBuildStatus(BuildStatusStarted, 1800)
 	return nil
 }
@@ -132,6 +163,24 @@ func (b *builder) Build() error {
 		"commit": b.job.Commit,
 	}).Info("Beginning to process repo")
 
+	if err := b.LoadFetchCredentials(); err != nil {
+		return err
+	}
+
+	if err := b.LoadSecretEnv(); err != nil {
+		return err
+	}
+
+	if err := b.WriteBuildState(); err != nil {
+		return err
+	}
+
+	// Pull request builds are not signed and don't get the signing key
+	gpgDecryptKey := conf.BuildImage.GPGDecryptKey
+	if b.job.IsIsolated() {
+		gpgDecryptKey = ""
+	}
+
 	cfg := &docker.Config{
 		AttachStdin:  false,
 		AttachStdout: true,
@@ -139,13 +188,19 @@ func (b *builder) Build() error {
 		Image:        conf.BuildImage.ImageName,
 		Env: []string{
 			fmt.Sprintf("REPO=%s", b.job.Repository),
-			fmt.Sprintf("GPG_DECRYPT_KEY=%s", conf.BuildImage.GPGDecryptKey),
+			fmt.Sprintf("GPG_DECRYPT_KEY=%s", gpgDecryptKey),
 			fmt.Sprintf("COMMIT=%s", b.job.Commit),
+			fmt.Sprintf("LABEL=%s", b.job.Label),
+			fmt.Sprintf("PR_REF=%s", b.job.Ref),
+			fmt.Sprintf("FORCE_BUILD=%t", b.job.Force),
 		},
 	}
 
 	hcfg := &docker.HostConfig{
-		Binds:        []string{fmt.Sprintf("%s:/artifacts", b.tmpDir)},
+		Binds: []string{
+			fmt.Sprintf("%s:/artifacts", b.tmpDir),
+			fmt.Sprintf("%s:/input", b.inputDir),
+		},
 		Privileged:   false,
 		PortBindings: make(map[docker.Port][]docker.PortBinding),
 	}
@@ -165,11 +220,21 @@ func (b *builder) Build() error {
 		return err
 	}
 
-	if err := redisClient.Set(fmt.Sprintf("project::%s::build-status", b.job.Repository), "building", 0, 0, false, false); err != nil {
-		return err
+	if !b.job.IsIsolated() {
+		if err := redisClient.Set(fmt.Sprintf("project::%s::build-status", b.job.Repository), "building", 0, 0, false, false); err != nil {
+			return err
+		}
 	}
 
+	stopWatch := make(chan struct{})
+	cancelled := make(chan bool, 1)
+	go b.watchCancel(stopWatch, cancelled)
 	status, err := dockerClient.WaitContainer(container.ID)
+	close(stopWatch)
+	b.removeInput()
+	if <-cancelled {
+		b.AbortReason = "Build was cancelled by an owner"
+	}
 	if err != nil {
 		return err
 	}
@@ -186,15 +251,113 @@ func (b *builder) Build() error {
 		b.UploadRequired = false
 	}
 
-	b.buildConfig, err = buildconfig.LoadFromFile(fmt.Sprintf("%s/.gobuilder.yml", b.tmpDir))
+	configFile := fmt.Sprintf("%s/.gobuilder.yml", b.tmpDir)
+	b.buildConfig, err = buildconfig.LoadFromFile(configFile)
 	if err != nil {
 		// We got no .gobuilder.yml? Assume something was terribly wrong and requeue build.
 		b.BuildOK = false
+
+		if _, statErr := os.Stat(configFile); statErr == nil {
+			// The file is present but invalid, requeueing will not help
+			b.AbortReason = fmt.Sprintf("Your .gobuilder.yml is invalid: %s", err)
+		}
+	} else if !b.job.IsIsolated() {
+		if err := b.StoreEnvConfig(); err != nil {
+			log.WithFields(logrus.Fields{
+				"host":  hostname,
+				"error": err,
+				"repo":  b.job.Repository,
+			}).Error("Unable to store env configuration")
+		}
+		if err := b.StoreRetentionPolicy(); err != nil {
+			log.WithFields(logrus.Fields{
+				"host":  hostname,
+				"error": err,
+				"repo":  b.job.Repository,
+			}).Error("Unable to store retention policy")
+		}
 	}
 
 	return nil
 }
 
+// WriteBuildState hands the commits already built and the build.db of the
+// repository to the build script
+func (b *builder) WriteBuildState() error {
+	commits, err := redisClient.ZRange(fmt.Sprintf("project::%s::built-commits", b.job.Repository), 0, -1, false)
+	if err != nil {
+		return err
+	}
+	if err := b.writeInput("built_commits", strings.Join(commits, "\n")+"\n"); err != nil {
+		return err
+	}
+
+	buildDB, err := redisClient.Get(fmt.Sprintf("project::%s::builddb", b.job.Repository))
+	if err != nil {
+		return err
+	}
+	if len(buildDB) == 0 {
+		// Fall back to old storage method, new repositories have none
+		if buildDB, err = s3Bucket.Get(fmt.Sprintf("%s/build.db", b.job.Repository)); err != nil {
+			return nil
+		}
+	}
+	return b.writeInput("build.db", string(buildDB))
+}
+
+// writeInput stores a file for the build script in the input directory.
+// The script deletes it after reading it, before any code of the
+// repository is executed.
+func (b *builder) writeInput(name, content string) error {
+	return ioutil.WriteFile(fmt.Sprintf("%s/%s", b.inputDir, name), []byte(content), 0600)
+}
+
+// removeInput deletes the input directory and all files not consumed by
+// the build script
+func (b *builder) removeInput() {
+	if b.inputDir == "" {
+		return
+	}
+	if err := os.RemoveAll(b.inputDir); err != nil {
+		log.WithFields(logrus.Fields{
+			"host":  hostname,
+			"error": err,
+			"repo":  b.job.Repository,
+		}).Error("Unable to remove build input")
+	}
+}
+
+// watchCancel kills the build container as soon as an owner requested to
+// cancel the build
+func (b *builder) watchCancel(stop chan struct{}, cancelled chan bool) {
+	cancelKey := fmt.Sprintf("project::%s::cancel", b.job.Repository)
+	redisClient.Del(cancelKey)
+
+	for {
+		select {
+		case <-stop:
+			cancelled <- false
+			return
+		case <-time.After(5 * time.Second):
+		}
+
+		if cancel, err := redisClient.Get(cancelKey); err != nil || string(cancel) != "true" {
+			continue
+		}
+
+		redisClient.Del(cancelKey)
+		cancelled <- true
+		if err := dockerClient.KillContainer(docker.KillContainerOptions{ID: b.container.ID}); err != nil {
+			log.WithFields(logrus.Fields{
+				"host":  hostname,
+				"error": err,
+				"repo":  b.job.Repository,
+			}).Error("Unable to kill cancelled build")
+		}
+		return
+	}
+}
+
 func (b *builder) FetchBuildLog() error {
 	var buf bytes.Buffer
 	w := bufio.NewWriter(&buf)
@@ -220,15 +383,24 @@ func (b *builder) WriteBuildLog() error {
 	projectLog := fmt.Sprintf("project::%s::logs", b.job.Repository)
 
 	buildID := fmt.Sprintf("%x", sha256.Sum256([]byte(strconv.FormatInt(time.Now().UnixNano(), 10))))[0:16]
-	if err := redisClient.Set(fmt.Sprintf("%s::%s", projectLog, buildID), b.BuildLog, 0, 0, false, false); err != nil {
+	buildLog := logstore.Truncate(logstore.Mask(b.BuildLog, b.secretValues), conf.LogMaxSize)
+	if err := logstore.Write(redisClient, b.job.Repository, buildID, buildLog); err != nil {
 		return err
 	}
+	b.buildLogID = buildID
 
 	logMeta := buildjob.BuildLog{
 		Success: b.BuildOK,
 		Time:    time.Now(),
 		ID:      buildID,
+		Commit:  b.job.Commit,
 	}
+	// The commit is only written by builds getting to the point of
+	// uploading their assets
+	if gitHash, err := ioutil.ReadFile(fmt.Sprintf("%s/.build_commit", b.tmpDir)); err == nil {
+		logMeta.Commit = strings.TrimSpace(string(gitHash))
+	}
+	logMeta.Steps, logMeta.Platforms = buildjob.ParseTimeline(buildLog)
 	meta, err := logMeta.ToString()
 	if err != nil {
 		return err
@@ -259,7 +431,8 @@ func (b *builder) WriteBuildLog() error {
 					continue // There are old build logs which can't be parsed
 				}
 
-				redisClient.Del(fmt.Sprintf("%s::%s", projectLog, m.ID))
+				logstore.Delete(redisClient, b.job.Repository, m.ID)
+				redisClient.Del(fmt.Sprintf("%s::%s::notifications", projectLog, m.ID))
 
 				if _, err := redisClient.ZRem(projectLog, meta); err != nil {
 					return err
@@ -276,49 +449,37 @@ func (b *builder) WriteBuildLog() error {
 	return nil
 }
 
-func (b *builder) UploadAssets() error {
-	assets, err := ioutil.ReadDir(b.tmpDir)
-	if err != nil {
-		return err
+// updateSearchIndex lists the repository in the search or removes it if it
+// is private or blocked
+func (b *builder) updateSearchIndex() error {
+	if blocked, _ := blockedRepos.IsBlocked(b.job.Repository); blocked || b.private {
+		return search.Remove(redisClient, b.job.Repository)
 	}
+	return search.Add(redisClient, search.NewEntry(b.job.Repository, time.Now()))
+}
 
-	for _, f := range assets {
-		if f.IsDir() {
-			// Some repos are creating directories. Don't know why. Ignore them.
-			continue
-		}
-
-		if strings.HasPrefix(f.Name(), ".") {
-			// Dotfiles are used to transport metadata from the container
-			continue
-		}
-
-		log.WithFields(logrus.Fields{
-			"host": hostname,
-		}).Debugf("Uploading asset %s...", f.Name())
-		originalPath := fmt.Sprintf("%s/%s", b.tmpDir, f.Name())
-		path := fmt.Sprintf("%s/%s", b.job.Repository, f.Name())
-		fileContent, err := ioutil.ReadFile(originalPath)
-		if err != nil {
-			return err
+func (b *builder) UpdateMetaData() error {
+	// Pull request builds must not show up as regular builds of the repo
+	if !b.job.IsIsolated() {
+		// Only write build-duration if this was a build with assets
+		redisClient.Set(fmt.Sprintf("project::%s::build-duration", b.job.Repository), fmt.Sprintf("%d", int(time.Now().Sub(b.buildStartTime).Seconds())), 0, 0, false, false)
+
+		// Private repositories must not be listed publicly
+		if !b.private {
+			redisClient.ZAdd("last-builds", map[string]float64{
+				b.job.Repository: float64(time.Now().UTC().Unix()),
+			})
 		}
 
-		err = s3Bucket.Put(path, fileContent, "", s3.PublicRead)
-		if err != nil {
-			return err
+		if err := b.updateSearchIndex(); err != nil {
+			log.WithFields(logrus.Fields{
+				"host":  hostname,
+				"error": err,
+				"repo":  b.job.Repository,
+			}).Error("Unable to update search index")
 		}
 	}
 
-	return nil
-}
-
-func (b *builder) UpdateMetaData() error {
-	// Only write build-duration if this was a build with assets
-	redisClient.Set(fmt.Sprintf("project::%s::build-duration", b.job.Repository), fmt.Sprintf("%d", int(time.Now().Sub(b.buildStartTime).Seconds())), 0, 0, false, false)
-	redisClient.ZAdd("last-builds", map[string]float64{
-		b.job.Repository: float64(time.Now().UTC().Unix()),
-	})
-
 	// Handle signature output
 	builtTagsRaw, err := ioutil.ReadFile(fmt.Sprintf("%s/.built_tags", b.tmpDir))
 	if err != nil {
@@ -326,6 +487,10 @@ func (b *builder) UpdateMetaData() error {
 	}
 	buildTags := strings.Split(string(builtTagsRaw), "\n")
 	for _, tag := range buildTags {
+		if strings.TrimSpace(tag) != "" {
+			b.builtTags = append(b.builtTags, strings.TrimSpace(tag))
+		}
+
 		signature, err := ioutil.ReadFile(fmt.Sprintf("%s/.signature_%s", b.tmpDir, tag))
 		if err != nil {
 			redisClient.Del(fmt.Sprintf("project::%s::signatures::%s", b.job.Repository, tag))
@@ -348,7 +513,6 @@ func (b *builder) UpdateMetaData() error {
 		}
 	}
 
-	// Log last build
 	gitHash, err := ioutil.ReadFile(fmt.Sprintf("%s/.build_commit", b.tmpDir))
 	if err != nil {
 		log.WithFields(logrus.Fields{
@@ -358,15 +522,30 @@ func (b *builder) UpdateMetaData() error {
 		}).Error("Unable to read gitHash")
 		gitHash = []byte("000000")
 	}
-	if _, err := redisClient.ZAdd(fmt.Sprintf("project::%s::built-commits", b.job.Repository), map[string]float64{
-		strings.TrimSpace(string(gitHash)): float64(time.Now().Unix()),
-	}); err != nil {
-		log.WithFields(logrus.Fields{
-			"host":  hostname,
-			"error": err,
-			"repo":  b.job.Repository,
-		}).Error("Unable to write last-build")
+	b.builtCommit = strings.TrimSpace(string(gitHash))
+
+	// Log last build (pull request commits are not yet part of the repo)
+	if !b.job.IsIsolated() {
+		if _, err := redisClient.ZAdd(fmt.Sprintf("project::%s::built-commits", b.job.Repository), map[string]float64{
+			b.builtCommit: float64(time.Now().Unix()),
+		}); err != nil {
+			log.WithFields(logrus.Fields{
+				"host":  hostname,
+				"error": err,
+				"repo":  b.job.Repository,
+			}).Error("Unable to write last-build")
+		}
 	}
+	if !b.job.IsIsolated() {
+		if err := b.StoreKnownRefs(); err != nil {
+			log.WithFields(logrus.Fields{
+				"host":  hostname,
+				"error": err,
+				"repo":  b.job.Repository,
+			}).Error("Unable to store known refs")
+		}
+	}
+
 	// Migration: Remove old storage type of last-build
 	redisClient.Del(fmt.Sprintf("project::%s::last-build", b.job.Repository))
 
@@ -381,6 +560,19 @@ func (b *builder) UpdateMetaData() error {
 		}).Error("Unable to read build.db")
 		return err
 	}
+	if err := json.Unmarshal(buildDB, &b.buildDB); err != nil {
+		log.WithFields(logrus.Fields{
+			"host":  hostname,
+			"error": err,
+			"repo":  b.job.Repository,
+		}).Error("Unable to parse build.db")
+	} else if err := b.RecordBuildHistory(); err != nil {
+		log.WithFields(logrus.Fields{
+			"host":  hostname,
+			"error": err,
+			"repo":  b.job.Repository,
+		}).Error("Unable to record build history")
+	}
 	if err := redisClient.Set(fmt.Sprintf("project::%s::builddb", b.job.Repository), string(buildDB), 0, 0, false, false); err != nil {
 		log.WithFields(logrus.Fields{
 			"host":  hostname,
@@ -395,33 +587,112 @@ func (b *builder) UpdateMetaData() error {
 }
 
 func (b *builder) SendNotifications() {
+	if b.job.IsIsolated() {
+		// Pull request builds report their status to the pull request
+		return
+	}
+
+	if b.buildConfig == nil {
+		// Without a valid .gobuilder.yml we don't know whom to notify
+		return
+	}
+
 	eventType := "success"
 	if !b.BuildOK {
 		eventType = "error"
 	}
 
-	encryptionKey, err := redisClient.Get(fmt.Sprintf("project::%s::encryption-key", b.job.Repository))
+	keyring, err := secrets.LoadKeyring(redisClient, b.job.Repository)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"host":  hostname,
 			"error": err,
 			"repo":  b.job.Repository,
 		}).Error("Unable to load encryption key")
+		keyring = &secrets.Keyring{}
+	}
+	b.updateReencryptHints(keyring)
+
+	assets := []builddb.Asset{}
+	for _, tag := range b.builtTags {
+		assets = append(assets, b.buildDB[tag].Assets...)
+	}
+
+	deliveries, err := b.buildConfig.Notify.Execute(notifier.NotifyMetaData{
+		EventType:   eventType,
+		Repository:  b.job.Repository,
+		Commit:      b.builtCommit,
+		Labels:      b.builtTags,
+		Duration:    time.Now().Sub(b.buildStartTime),
+		AbortReason: b.AbortReason,
+		Warnings:    b.warnings,
+		BuildLogID:  b.buildLogID,
+		Assets:      assets,
+		BaseURL:     baseURL(),
+	}, conf, keyring)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"host":  hostname,
+			"error": err,
+			"repo":  b.job.Repository,
+		}).Error("Unable to send notification")
 	}
 
-	if err := b.buildConfig.Notify.Execute(notifier.NotifyMetaData{
-		EventType:  eventType,
-		Repository: b.job.Repository,
-	}, conf, string(encryptionKey)); err != nil {
+	if err := b.writeDeliveryLog(deliveries); err != nil {
 		log.WithFields(logrus.Fields{
 			"host":  hostname,
 			"error": err,
 			"repo":  b.job.Repository,
-		}).Error("Unable to send notification")
+		}).Error("Unable to store notification deliveries")
 	}
 }
 
+// updateReencryptHints counts the secrets in the notify configuration
+// using the legacy format or a key which is not current anymore to hint
+// the owners to encrypt them again
+func (b *builder) updateReencryptHints(keyring *secrets.Keyring) {
+	outdated := 0
+	for _, method := range b.buildConfig.Notify {
+		for _, value := range []string{method.Target, method.Secret} {
+			if keyring.IsOutdated(value) {
+				outdated++
+			}
+		}
+	}
+
+	hintKey := fmt.Sprintf("project::%s::reencrypt-hints", b.job.Repository)
+	if outdated == 0 {
+		redisClient.Del(hintKey)
+		return
+	}
+	redisClient.Set(hintKey, strconv.Itoa(outdated), 0, 0, false, false)
+}
+
+// writeDeliveryLog stores the notification attempts next to the build log
+// to be displayed on the build log page
+func (b *builder) writeDeliveryLog(deliveries []notifier.Delivery) error {
+	if b.buildLogID == "" || len(deliveries) == 0 {
+		return nil
+	}
+
+	entries := []string{}
+	for _, d := range deliveries {
+		entry, err := d.ToString()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	_, err := redisClient.RPush(fmt.Sprintf("project::%s::logs::%s::notifications", b.job.Repository, b.buildLogID), entries...)
+	return err
+}
+
 func (b *builder) TriggerSubBuilds() {
+	if b.job.IsIsolated() {
+		return
+	}
+
 	if len(b.buildConfig.Triggers) > 20 {
 		// Flood / DDoS protection
 		log.WithFields(logrus.Fields{
@@ -453,7 +724,10 @@ func (b *builder) TriggerSubBuilds() {
 
 func (b *builder) Cleanup() {
 	redisClient.Del(fmt.Sprintf("project::%s::build-lock", b.job.Repository))
-	_ = os.RemoveAll(b.tmpDir)
+	if !b.keepTmpDir {
+		_ = os.RemoveAll(b.tmpDir)
+	}
+	b.removeInput()
 
 	log.WithFields(logrus.Fields{
 		"host": hostname,
@@ -462,7 +736,13 @@ func (b *builder) Cleanup() {
 }
 
 func (b *builder) UpdateBuildStatus(status string, expire int) {
-	if err := redisClient.Set(fmt.Sprintf("project::%s::build-status", b.job.Repository), status, expire, 0, false, false); err != nil {
+	statusKey := fmt.Sprintf("project::%s::build-status", b.job.Repository)
+	if b.job.IsIsolated() {
+		statusKey = fmt.Sprintf("%s::%s", statusKey, b.job.Label)
+		b.ReportPullRequestStatus(status)
+	}
+
+	if err := redisClient.Set(statusKey, status, expire, 0, false, false); err != nil {
 		log.WithFields(logrus.Fields{
 			"host": hostname,
 			"err":  err,
@@ -474,7 +754,7 @@ func (b *builder) UpdateBuildStatus(status string, expire int) {
 func (b *builder) IsBuildable() (bool, error) {
 	if strings.Contains(b.BuildLog, "could not read Username for 'https://github.com': No such device or address") {
 		// Someone tried to build a private GitHub repository
-		b.AbortReason = "GoBuilder is unable to build private repositories"
+		b.AbortReason = "GoBuilder is unable to build private repositories without access credentials"
 	}
 
 	if strings.Contains(b.BuildLog, "no buildable Go source files in") {
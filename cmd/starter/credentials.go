@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Luzifer/gobuilder/credentials"
+	"github.com/Luzifer/gobuilder/secrets"
+)
+
+// LoadFetchCredentials reads the credentials registered for private
+// repositories and writes them into the input directory of the build
+// container. The build script only uses them in the fetch phase.
+func (b *builder) LoadFetchCredentials() error {
+	raw, err := redisClient.Get(credentials.RedisKey(b.job.Repository))
+	if err != nil {
+		return err
+	}
+
+	if len(raw) == 0 {
+		return nil
+	}
+	b.private = true
+
+	creds, err := credentials.FromString(string(raw))
+	if err != nil {
+		return err
+	}
+
+	keyring, err := secrets.LoadKeyring(redisClient, credentials.SourceRepository(b.job.Repository))
+	if err != nil {
+		return err
+	}
+
+	secret, err := keyring.Decrypt(creds.Secret)
+	if err != nil {
+		return err
+	}
+
+	b.secretValues = append(b.secretValues, secret)
+
+	switch creds.Type {
+	case credentials.TypeSSHKey:
+		return b.writeInput("fetch_ssh_key", secret)
+	case credentials.TypeToken:
+		b.fetchToken = secret
+		return b.writeInput("fetch_token", secret)
+	}
+
+	return fmt.Errorf("Unsupported credentials type %q", creds.Type)
+}
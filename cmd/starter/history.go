@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/gobuilder/builddb"
+	"github.com/Sirupsen/logrus"
+)
+
+const historyMigrationLockTime = 3600
+
+// migrateBuildHistories creates the history of all repositories built
+// before the history was introduced. Repositories already migrated are
+// skipped by builddb.Migrate so this is cheap to run on every start, the
+// lock only prevents starters from migrating at the same time.
+func migrateBuildHistories() {
+	if err := redisClient.Set("history-migration-lock", hostname, historyMigrationLockTime, 0, false, true); err != nil {
+		return
+	}
+	if lock, err := redisClient.Get("history-migration-lock"); err != nil || string(lock) != hostname {
+		return
+	}
+	defer redisClient.Del("history-migration-lock")
+
+	var cursor uint64
+	for {
+		next, keys, err := redisClient.Scan(cursor, "project::*::builddb", 1000)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"host":  hostname,
+				"error": err,
+			}).Error("Unable to list build DBs for history migration")
+			return
+		}
+
+		for _, k := range keys {
+			repo := strings.TrimSuffix(strings.TrimPrefix(k, "project::"), "::builddb")
+			if err := migrateBuildHistory(repo); err != nil {
+				log.WithFields(logrus.Fields{
+					"host":  hostname,
+					"repo":  repo,
+					"error": err,
+				}).Error("Unable to migrate build history")
+			}
+		}
+
+		if next == 0 {
+			return
+		}
+		cursor = next
+	}
+}
+
+func migrateBuildHistory(repo string) error {
+	raw, err := redisClient.Get(fmt.Sprintf("project::%s::builddb", repo))
+	if err != nil || len(raw) == 0 {
+		return err
+	}
+
+	buildDB := builddb.BuildDB{}
+	if err := json.Unmarshal(raw, &buildDB); err != nil {
+		return err
+	}
+
+	return builddb.Migrate(redisClient, repo, buildDB)
+}
+
+// RecordBuildHistory adds a build record for every label built by this
+// build to the history of the label. Labels only known from the BuildDB
+// are migrated into the history before.
+func (b *builder) RecordBuildHistory() error {
+	if err := builddb.Migrate(redisClient, b.job.Repository, b.buildDB, b.builtTags...); err != nil {
+		return err
+	}
+
+	immutablePaths, err := b.immutableAssetPaths()
+	if err != nil {
+		return err
+	}
+
+	// Without a stored build log the builds can't be identified by it
+	buildID := b.buildLogID
+	if buildID == "" {
+		buildID = fmt.Sprintf("%s-%d", builddb.UnloggedBuildID, time.Now().UnixNano())
+	}
+
+	for _, tag := range b.builtTags {
+		branch, ok := b.buildDB[tag]
+		if !ok {
+			continue
+		}
+
+		assets := []builddb.Asset{}
+		for _, a := range branch.Assets {
+			a.Path = immutablePaths[a.SHA256]
+			assets = append(assets, a)
+		}
+
+		if err := builddb.AddBuild(redisClient, b.job.Repository, builddb.Build{
+			ID:        buildID,
+			Label:     tag,
+			Commit:    b.builtCommit,
+			GoVersion: branch.GoVersion,
+			BuildDate: branch.BuildDate,
+			Assets:    assets,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// immutableAssetPaths maps the SHA256 of the zips named by the built
+// commit to their storage key. The label zips are hardlinks of them so
+// their hashes are equal.
+func (b *builder) immutableAssetPaths() (map[string]string, error) {
+	paths := map[string]string{}
+
+	files, err := ioutil.ReadDir(b.tmpDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".zip") || !strings.Contains(f.Name(), "_"+b.builtCommit+"_") {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", b.tmpDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		paths[fmt.Sprintf("%x", sha256.Sum256(content))] = fmt.Sprintf("%s/%s", b.job.Repository, f.Name())
+	}
+
+	return paths, nil
+}
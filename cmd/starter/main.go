@@ -10,8 +10,11 @@ import (
 	"launchpad.net/goamz/aws"
 	"launchpad.net/goamz/s3"
 
+	"github.com/Luzifer/gobuilder/blocklist"
 	"github.com/Luzifer/gobuilder/buildjob"
 	"github.com/Luzifer/gobuilder/config"
+	"github.com/Luzifer/gobuilder/notifier"
+	"github.com/Luzifer/gobuilder/secrets"
 	"github.com/Sirupsen/logrus"
 	"github.com/cenkalti/backoff"
 	"github.com/fsouza/go-dockerclient"
@@ -21,6 +24,7 @@ import (
 
 var (
 	dockerClient        *docker.Client
+	blockedRepos        = &blocklist.Blocklist{}
 	log                 = logrus.New()
 	s3Bucket            *s3.Bucket
 	redisClient         *goredis.Redis
@@ -66,6 +70,27 @@ func init() {
 
 	connectRedis()
 
+	if err := secrets.SetWrapKey(conf.KeyringKey); err != nil {
+		log.WithFields(logrus.Fields{
+			"host": hostname,
+			"err":  err,
+		}).Fatal("Unable to set up keyring encryption")
+	}
+
+	if err := blockedRepos.LoadFromFile("blockedRepos.yml"); err != nil {
+		log.WithFields(logrus.Fields{
+			"host": hostname,
+			"err":  err,
+		}).Warn("Unable to load list of blocked repositories")
+	}
+
+	if err := notifier.SetupMailTransport(conf); err != nil {
+		log.WithFields(logrus.Fields{
+			"host": hostname,
+			"err":  err,
+		}).Fatal("Unable to set up mail transport")
+	}
+
 	awsAuth, err := aws.EnvAuth()
 	if err != nil {
 		log.WithFields(logrus.Fields{
@@ -90,6 +115,14 @@ func init() {
 	hostname, err = os.Hostname()
 }
 
+// baseURL returns the URL the frontend is reachable at without trailing slash
+func baseURL() string {
+	if conf.BaseURL == "" {
+		return "https://gobuilder.me"
+	}
+	return strings.TrimRight(conf.BaseURL, "/")
+}
+
 func connectRedis() {
 	var err error
 	redisClient, err = goredis.DialURL(conf.RedisURL)
@@ -115,6 +148,8 @@ func main() {
 		"host": hostname,
 	}).Infof("Build starter version %s with %d build slots in service.", version, maxConcurrentBuilds)
 
+	go migrateBuildHistories()
+
 	c := cron.New()
 	c.AddFunc("0 * * * * *", announceActiveWorker)
 	c.AddFunc("0 */30 * * * *", func() {
@@ -126,6 +161,15 @@ func main() {
 			}).Error("Unable to refresh build image")
 		}
 	})
+	c.AddFunc("0 15 * * * *", pruneSuspendedUploads)
+	if conf.Retention.GCSchedule != "" {
+		if err := c.AddFunc(conf.Retention.GCSchedule, collectGarbage); err != nil {
+			log.WithFields(logrus.Fields{
+				"host":  hostname,
+				"error": err,
+			}).Error("Unable to schedule garbage collection")
+		}
+	}
 	c.AddFunc("*/10 * * * * *", func() {
 		if !killswitch {
 			go doBuildProcess()
@@ -195,8 +239,14 @@ func doBuildProcess() {
 		return
 	}
 
-	// Prepare everything for the build or put back the job and stop if we can't
-	if err = builder.PrepareBuild(conf.TmpDir); err != nil {
+	resumed := builder.ResumeUpload()
+	if resumed {
+		log.WithFields(logrus.Fields{
+			"host": hostname,
+			"repo": builder.job.Repository,
+		}).Info("Resuming upload of a former build")
+	} else if err = builder.PrepareBuild(conf.TmpDir); err != nil {
+		// Prepare everything for the build or put back the job and stop if we can't
 		log.WithFields(logrus.Fields{
 			"host": hostname,
 			"err":  err,
@@ -210,6 +260,54 @@ func doBuildProcess() {
 	// Ensure we don't make a mess after we're done
 	defer builder.Cleanup()
 
+	if !resumed && !runBuild(builder) {
+		return
+	}
+
+	// Handle the uploads
+	if builder.UploadRequired {
+		if err := builder.UploadAssets(); err != nil {
+			log.WithFields(logrus.Fields{
+				"host": hostname,
+				"err":  err,
+				"repo": builder.job.Repository,
+			}).Error("Was unable to upload the build assets")
+
+			if err := builder.SuspendUpload(); err != nil {
+				log.WithFields(logrus.Fields{
+					"host": hostname,
+					"err":  err,
+					"repo": builder.job.Repository,
+				}).Error("Unable to keep the build assets for a later upload")
+			}
+			builder.PutBackJob(false)
+			return
+		}
+	}
+
+	builder.UpdateBuildStatus(BuildStatusFinished, 0)
+
+	if builder.UploadRequired {
+		if err := builder.UpdateMetaData(); err != nil {
+			log.WithFields(logrus.Fields{
+				"host": hostname,
+				"err":  err,
+				"repo": builder.job.Repository,
+			}).Error("There was an error while updating metadata")
+
+			builder.PutBackJob(false)
+			return
+		}
+	}
+
+	// Send success notifications
+	builder.SendNotifications()
+	builder.TriggerSubBuilds()
+}
+
+// runBuild builds the job and stores its log. It returns false if the
+// processing of the job ended because the build failed.
+func runBuild(builder *builder) bool {
 	// Do the real build
 	if err := builder.Build(); err != nil {
 		log.WithFields(logrus.Fields{
@@ -219,7 +317,7 @@ func doBuildProcess() {
 		}).Error("Build failed")
 
 		builder.PutBackJob(true)
-		return
+		return false
 	}
 
 	// Handle the build log
@@ -231,7 +329,15 @@ func doBuildProcess() {
 		}).Error("Was unable to fetch the log from the container")
 
 		builder.PutBackJob(false)
-		return
+		return false
+	}
+
+	if err := builder.CheckSizeBudget(); err != nil {
+		log.WithFields(logrus.Fields{
+			"host": hostname,
+			"err":  err,
+			"repo": builder.job.Repository,
+		}).Error("Was unable to check the size budget")
 	}
 
 	if err := builder.WriteBuildLog(); err != nil {
@@ -258,41 +364,11 @@ func doBuildProcess() {
 				"repo": builder.job.Repository,
 			}).Errorf("Build failed and is not buildable: %s", builder.AbortReason)
 			builder.UpdateBuildStatus(BuildStatusFailed, 0)
+			builder.SendNotifications()
 		}
 
-		return
+		return false
 	}
 
-	// Handle the uploads
-	if builder.UploadRequired {
-		if err := builder.UploadAssets(); err != nil {
-			log.WithFields(logrus.Fields{
-				"host": hostname,
-				"err":  err,
-				"repo": builder.job.Repository,
-			}).Error("Was unable to upload the build assets")
-
-			builder.PutBackJob(false)
-			return
-		}
-	}
-
-	builder.UpdateBuildStatus(BuildStatusFinished, 0)
-
-	if builder.UploadRequired {
-		if err := builder.UpdateMetaData(); err != nil {
-			log.WithFields(logrus.Fields{
-				"host": hostname,
-				"err":  err,
-				"repo": builder.job.Repository,
-			}).Error("There was an error while updating metadata")
-
-			builder.PutBackJob(false)
-			return
-		}
-	}
-
-	// Send success notifications
-	builder.SendNotifications()
-	builder.TriggerSubBuilds()
+	return true
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const pullRequestStatusContext = "gobuilder"
+
+type githubCommitStatus struct {
+	State       string `json:"state"`
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+}
+
+// ReportPullRequestStatus sends the current build status of a pull request
+// build back to the code hosting platform to be displayed on the PR
+func (b *builder) ReportPullRequestStatus(status string) {
+	var err error
+
+	switch {
+	case strings.HasPrefix(b.job.Repository, "github.com/"):
+		err = b.reportGitHubStatus(status)
+	case strings.HasPrefix(b.job.Repository, gitlabHost()+"/"):
+		err = b.reportGitLabStatus(status)
+	default:
+		return
+	}
+
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"host":  hostname,
+			"error": err,
+			"repo":  b.job.Repository,
+			"label": b.job.Label,
+		}).Error("Unable to report pull request status")
+	}
+}
+
+func (b *builder) pullRequestTargetURL() string {
+	return fmt.Sprintf("%s/%s?branch=%s", baseURL(), b.job.Repository, b.job.Label)
+}
+
+func (b *builder) reportGitHubStatus(status string) error {
+	if conf.GitHub.StatusToken == "" {
+		return nil
+	}
+
+	matches := regexp.MustCompile("^github.com/([^/]+)/([^/]+)").FindStringSubmatch(b.job.Repository)
+	if matches == nil {
+		return fmt.Errorf("Repository %q is no GitHub repository", b.job.Repository)
+	}
+
+	state := map[string]string{
+		BuildStatusQueued:   "pending",
+		BuildStatusStarted:  "pending",
+		BuildStatusFinished: "success",
+		BuildStatusFailed:   "failure",
+	}[status]
+
+	body, err := json.Marshal(githubCommitStatus{
+		State:       state,
+		TargetURL:   b.pullRequestTargetURL(),
+		Description: fmt.Sprintf("GoBuilder build is %s", status),
+		Context:     pullRequestStatusContext,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("https://api.github.com/repos/%s/%s/statuses/%s", matches[1], matches[2], b.job.Commit), bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "token "+conf.GitHub.StatusToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("GitHub responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (b *builder) reportGitLabStatus(status string) error {
+	if conf.GitLab.StatusToken == "" {
+		return nil
+	}
+
+	project := strings.TrimPrefix(b.job.Repository, gitlabHost()+"/")
+	state := map[string]string{
+		BuildStatusQueued:   "pending",
+		BuildStatusStarted:  "running",
+		BuildStatusFinished: "success",
+		BuildStatusFailed:   "failed",
+	}[status]
+
+	params := url.Values{
+		"state":       []string{state},
+		"name":        []string{pullRequestStatusContext},
+		"target_url":  []string{b.pullRequestTargetURL()},
+		"description": []string{fmt.Sprintf("GoBuilder build is %s", status)},
+	}
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/v4/projects/%s/statuses/%s",
+		strings.TrimRight(conf.GitLab.URL, "/"), url.QueryEscape(project), b.job.Commit), bytes.NewBuffer([]byte(params.Encode())))
+	req.Header.Set("PRIVATE-TOKEN", conf.GitLab.StatusToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("GitLab responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func gitlabHost() string {
+	u, err := url.Parse(conf.GitLab.URL)
+	if err != nil || u.Host == "" {
+		return "gitlab.com"
+	}
+	return u.Host
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Luzifer/gobuilder/buildconfig"
+	"github.com/Luzifer/gobuilder/retention"
+	"github.com/Sirupsen/logrus"
+)
+
+const gcLockTime = 6 * 3600
+
+// StoreRetentionPolicy remembers the retention policy of the .gobuilder.yml
+// for the garbage collection running outside of builds
+func (b *builder) StoreRetentionPolicy() error {
+	key := retention.PolicyKey(b.job.Repository)
+	if b.buildConfig == nil || b.buildConfig.Retention == nil {
+		_, err := redisClient.Del(key)
+		return err
+	}
+
+	raw, err := json.Marshal(b.buildConfig.Retention)
+	if err != nil {
+		return err
+	}
+	return redisClient.Set(key, string(raw), 0, 0, false, false)
+}
+
+// StoreKnownRefs stores the branches and tags present in the source
+// repository while building to detect labels of removed branches
+func (b *builder) StoreKnownRefs() error {
+	raw, err := ioutil.ReadFile(fmt.Sprintf("%s/.known_refs", b.tmpDir))
+	if err != nil {
+		// Builds using an older build image do not provide the refs
+		return nil
+	}
+
+	branches, tags := []string{}, []string{}
+	for _, line := range strings.Split(string(raw), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "branch":
+			branches = append(branches, parts[1])
+		case "tag":
+			tags = append(tags, parts[1])
+		}
+	}
+
+	if len(branches) == 0 {
+		// Something went wrong while collecting, don't risk deleting labels
+		return nil
+	}
+
+	for key, refs := range map[string][]string{
+		retention.KnownBranchesKey(b.job.Repository): branches,
+		retention.KnownTagsKey(b.job.Repository):     tags,
+	} {
+		if _, err := redisClient.Del(key); err != nil {
+			return err
+		}
+		if len(refs) == 0 {
+			continue
+		}
+		if _, err := redisClient.SAdd(key, refs...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectGarbage applies the retention policies and removes unreferenced
+// artifacts. Only one starter runs the collection at a time.
+func collectGarbage() {
+	if err := redisClient.Set("gc-lock", hostname, gcLockTime, 0, false, true); err != nil {
+		return
+	}
+	if lock, err := redisClient.Get("gc-lock"); err != nil || string(lock) != hostname {
+		return
+	}
+
+	keepTags := conf.Retention.KeepTags
+	deleteRemovedBranches := conf.Retention.DeleteRemovedBranches
+	collector := &retention.Collector{
+		Redis:  redisClient,
+		Bucket: s3Bucket,
+		Policy: buildconfig.RetentionPolicy{
+			KeepBuilds:            conf.Retention.KeepBuilds,
+			KeepTags:              &keepTags,
+			DeleteRemovedBranches: &deleteRemovedBranches,
+			MaxAge:                conf.Retention.MaxAge,
+		},
+		DryRun: conf.Retention.GCDryRun,
+	}
+
+	reports, err := collector.Run()
+	for _, r := range reports {
+		if r.Error != "" {
+			log.WithFields(logrus.Fields{
+				"host":  hostname,
+				"repo":  r.Repository,
+				"error": r.Error,
+			}).Error("Unable to collect garbage of repository")
+			continue
+		}
+
+		if r.Skipped != "" || (len(r.DeletedLabels) == 0 && len(r.DeletedBuilds) == 0 && len(r.DeletedObjects) == 0) {
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			"host":            hostname,
+			"repo":            r.Repository,
+			"dry_run":         conf.Retention.GCDryRun,
+			"deleted_labels":  strings.Join(r.DeletedLabels, ","),
+			"deleted_builds":  strings.Join(r.DeletedBuilds, ","),
+			"deleted_objects": strings.Join(r.DeletedObjects, ","),
+			"freed_bytes":     r.FreedBytes,
+		}).Info("Collected garbage")
+	}
+
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"host":  hostname,
+			"error": err,
+		}).Error("Garbage collection failed")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/Luzifer/gobuilder/builddb"
+	"github.com/Luzifer/gobuilder/downloads"
+)
+
+// CheckSizeBudget compares the sizes of the built binaries to the size
+// budget of the .gobuilder.yml. Depending on the budget violations are
+// sent as warnings with the notifications or fail the build.
+func (b *builder) CheckSizeBudget() error {
+	if !b.BuildOK || !b.UploadRequired || b.buildConfig == nil || b.buildConfig.SizeBudget == nil {
+		return nil
+	}
+	budget := b.buildConfig.SizeBudget
+
+	sizes, err := b.binarySizes()
+	if err != nil {
+		return err
+	}
+	previous, err := b.previousBinarySizes()
+	if err != nil {
+		return err
+	}
+
+	platforms := []string{}
+	for platform := range sizes {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+
+	violations := []string{}
+	for _, platform := range platforms {
+		found, err := budget.Check(sizes[platform], previous[platform])
+		if err != nil {
+			return err
+		}
+		for _, v := range found {
+			violations = append(violations, fmt.Sprintf("%s binary %s", platform, v))
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	if budget.ShouldFail() {
+		// Building again will not change the size, IsBuildable reports
+		// the abort reason and prevents the requeue
+		b.BuildOK = false
+		b.UploadRequired = false
+		b.AbortReason = fmt.Sprintf("Size budget exceeded: %s", strings.Join(violations, "; "))
+		return nil
+	}
+
+	b.warnings = append(b.warnings, violations...)
+	return nil
+}
+
+// binarySizes returns the size of the uncompressed binary of every
+// platform built. All labels share the same binaries.
+func (b *builder) binarySizes() (map[string]int64, error) {
+	files, err := ioutil.ReadDir(b.tmpDir)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := map[string]int64{}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || strings.HasSuffix(f.Name(), ".zip") {
+			continue
+		}
+		if platform := downloads.Platform(f.Name()); platform != "" {
+			sizes[platform] = f.Size()
+		}
+	}
+
+	return sizes, nil
+}
+
+// previousBinarySizes returns the binary sizes of the current builds of
+// the labels built. Labels built for the first time are not compared.
+func (b *builder) previousBinarySizes() (map[string]int64, error) {
+	builtTags, err := ioutil.ReadFile(fmt.Sprintf("%s/.built_tags", b.tmpDir))
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := map[string]int64{}
+	for _, tag := range strings.Split(string(builtTags), "\n") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+
+		current, err := builddb.CurrentBuild(redisClient, b.job.Repository, tag)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			continue
+		}
+
+		for _, a := range current.Assets {
+			platform := downloads.Platform(a.FileName)
+			if _, ok := sizes[platform]; !ok && platform != "" && a.BinarySize > 0 {
+				sizes[platform] = a.BinarySize
+			}
+		}
+	}
+
+	return sizes, nil
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"launchpad.net/goamz/s3"
+
+	"github.com/Luzifer/gobuilder/assetstore"
+	"github.com/Luzifer/gobuilder/buildconfig"
+	"github.com/Sirupsen/logrus"
+	"github.com/cenkalti/backoff"
+)
+
+const (
+	uploadStateFile = ".upload_state"
+	// Directories of suspended uploads not resumed within this time are
+	// removed as the job was picked up by another starter
+	uploadStateMaxAge = 24 * time.Hour
+)
+
+// uploadState is stored next to the assets of a build whose upload failed
+// to resume the upload without rebuilding when the job is picked up again
+type uploadState struct {
+	BuildLogID     string    `json:"build_log_id"`
+	BuildStartTime time.Time `json:"build_start_time"`
+	Private        bool      `json:"private"`
+	Warnings       []string  `json:"warnings,omitempty"`
+}
+
+// limitedBackOff stops retrying after a number of retries
+type limitedBackOff struct {
+	backoff.BackOff
+	maxRetries int
+	retries    int
+}
+
+func (l *limitedBackOff) NextBackOff() time.Duration {
+	if l.retries >= l.maxRetries {
+		return backoff.Stop
+	}
+	l.retries++
+	return l.BackOff.NextBackOff()
+}
+
+func (l *limitedBackOff) Reset() {
+	l.retries = 0
+	l.BackOff.Reset()
+}
+
+// UploadAssets stores the assets of the build in the content-addressed
+// storage and points the file names of the labels to them afterwards.
+// Objects already stored by former builds or a failed upload attempt are
+// not uploaded again.
+func (b *builder) UploadAssets() error {
+	assets, err := ioutil.ReadDir(b.tmpDir)
+	if err != nil {
+		return err
+	}
+
+	stored, err := assetstore.Objects(s3Bucket, b.job.Repository)
+	if err != nil {
+		return err
+	}
+
+	refs := map[string]string{}
+	pending := map[string]os.FileInfo{}
+	for _, f := range assets {
+		if f.IsDir() {
+			// Some repos are creating directories. Don't know why. Ignore them.
+			continue
+		}
+
+		if strings.HasPrefix(f.Name(), ".") {
+			// Dotfiles are used to transport metadata from the container
+			continue
+		}
+
+		sha, err := hashFile(filepath.Join(b.tmpDir, f.Name()))
+		if err != nil {
+			return err
+		}
+		refs[f.Name()] = sha
+
+		if _, ok := stored[sha]; !ok {
+			pending[sha] = f
+		}
+	}
+
+	if err := b.uploadObjects(pending); err != nil {
+		return err
+	}
+
+	// Labels are only switched to the new assets after all were uploaded
+	for name, sha := range refs {
+		if err := assetstore.SetRef(redisClient, b.job.Repository, name, sha); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uploadObjects uploads the files keyed by their SHA256 using a limited
+// number of parallel uploads
+func (b *builder) uploadObjects(pending map[string]os.FileInfo) error {
+	concurrency := conf.Upload.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errLock  sync.Mutex
+		firstErr error
+		slots    = make(chan struct{}, concurrency)
+	)
+
+	for sha, f := range pending {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(sha string, f os.FileInfo) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			if err := b.uploadObject(sha, f); err != nil {
+				errLock.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("Upload of %s failed: %s", f.Name(), err)
+				}
+				errLock.Unlock()
+			}
+		}(sha, f)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// uploadObject streams a single file into the storage retrying failed
+// attempts with an exponential backoff
+func (b *builder) uploadObject(sha string, f os.FileInfo) error {
+	key := assetstore.ObjectPath(b.job.Repository, sha)
+	partSize := int64(conf.Upload.PartSize) * 1024 * 1024
+
+	acl := s3.PublicRead
+	if b.private {
+		// Private assets are only delivered through signed URLs
+		acl = s3.Private
+	}
+
+	upload := func() error {
+		file, err := os.Open(filepath.Join(b.tmpDir, f.Name()))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if partSize <= 0 || f.Size() <= partSize {
+			return s3Bucket.PutReader(key, file, f.Size(), "", acl)
+		}
+
+		// Multi continues an unfinished upload of the object, parts
+		// already sent are not uploaded again
+		multi, err := s3Bucket.Multi(key, "", acl)
+		if err != nil {
+			return err
+		}
+		parts, err := multi.PutAll(file, partSize)
+		if err != nil {
+			return err
+		}
+		return multi.Complete(parts)
+	}
+
+	log.WithFields(logrus.Fields{
+		"host": hostname,
+		"repo": b.job.Repository,
+		"size": f.Size(),
+	}).Debugf("Uploading asset %s...", f.Name())
+
+	return backoff.RetryNotify(upload, &limitedBackOff{
+		BackOff:    backoff.NewExponentialBackOff(),
+		maxRetries: conf.Upload.Retries,
+	}, func(err error, wait time.Duration) {
+		log.WithFields(logrus.Fields{
+			"host":  hostname,
+			"repo":  b.job.Repository,
+			"error": err,
+			"wait":  wait,
+		}).Warnf("Upload of asset %s failed, retrying", f.Name())
+	})
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// SuspendUpload keeps the assets of the build and remembers them in the
+// job to resume the upload when the job is picked up again
+func (b *builder) SuspendUpload() error {
+	raw, err := json.Marshal(uploadState{
+		BuildLogID:     b.buildLogID,
+		BuildStartTime: b.buildStartTime,
+		Private:        b.private,
+		Warnings:       b.warnings,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(b.tmpDir, uploadStateFile), raw, 0600); err != nil {
+		return err
+	}
+
+	b.keepTmpDir = true
+	b.job.UploadDir = b.tmpDir
+	b.job.UploadHost = hostname
+	return nil
+}
+
+// ResumeUpload restores the builder from a suspended upload and reports
+// whether the build can be skipped. Suspended uploads of other starters
+// cannot be resumed and the job is built again.
+func (b *builder) ResumeUpload() bool {
+	dir, host := b.job.UploadDir, b.job.UploadHost
+	b.job.UploadDir, b.job.UploadHost = "", ""
+	if dir == "" || host != hostname {
+		return false
+	}
+
+	raw, err := ioutil.ReadFile(filepath.Join(dir, uploadStateFile))
+	if err != nil {
+		return false
+	}
+
+	state := uploadState{}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return false
+	}
+
+	cfg, err := buildconfig.LoadFromFile(filepath.Join(dir, ".gobuilder.yml"))
+	if err != nil {
+		return false
+	}
+
+	os.Remove(filepath.Join(dir, uploadStateFile))
+
+	b.tmpDir = dir
+	b.buildConfig = cfg
+	b.buildLogID = state.BuildLogID
+	b.buildStartTime = state.BuildStartTime
+	b.private = state.Private
+	b.warnings = state.Warnings
+	b.BuildOK = true
+	b.UploadRequired = true
+
+	b.UpdateBuildStatus(BuildStatusStarted, 1800)
+	return true
+}
+
+// pruneSuspendedUploads removes the assets of suspended uploads which were
+// not resumed by this starter
+func pruneSuspendedUploads() {
+	baseDir := conf.TmpDir
+	if baseDir == "" {
+		baseDir = os.TempDir()
+	}
+
+	states, err := filepath.Glob(filepath.Join(baseDir, "gobuild*", uploadStateFile))
+	if err != nil {
+		return
+	}
+
+	for _, state := range states {
+		info, err := os.Stat(state)
+		if err != nil || time.Since(info.ModTime()) < uploadStateMaxAge {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Dir(state)); err != nil {
+			log.WithFields(logrus.Fields{
+				"host":  hostname,
+				"error": err,
+			}).Error("Unable to remove suspended upload")
+		}
+	}
+}
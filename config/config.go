@@ -10,9 +10,34 @@ type Config struct {
 	Port     int    `env:"PORT"` // Deprecated, only for gin
 	TmpDir   string `flag:"tmp-dir" default:""`
 
+	LogMaxSize int `env:"log_max_size" flag:"log-max-size" default:"1048576"` // Maximum size of stored build logs in bytes
+
+	KeyringKey string `env:"keyring_key" flag:"keyring-key"` // Encrypts the keys of the repository keyrings stored in Redis
+
+	Upload struct {
+		Concurrency int `env:"upload_concurrency" flag:"upload-concurrency" default:"4"`
+		Retries     int `env:"upload_retries" flag:"upload-retries" default:"5"`
+		PartSize    int `env:"upload_part_size" flag:"upload-part-size" default:"16"` // Size of multipart upload parts in MiB, smaller files are uploaded in one request
+	}
+
+	Retention struct {
+		KeepBuilds            int    `env:"retention_keep_builds" flag:"retention-keep-builds" default:"10"`
+		KeepTags              bool   `env:"retention_keep_tags" flag:"retention-keep-tags" default:"true"`
+		DeleteRemovedBranches bool   `env:"retention_delete_removed_branches" flag:"retention-delete-removed-branches" default:"true"`
+		MaxAge                string `env:"retention_max_age" flag:"retention-max-age"`
+		GCSchedule            string `env:"gc_schedule" flag:"gc-schedule" default:"0 30 3 * * *"`
+		GCDryRun              bool   `env:"gc_dry_run" flag:"gc-dry-run"`
+	}
+
 	GitHub struct {
 		ClientID     string `env:"github_client_id" flag:"github-client-id"`
 		ClientSecret string `env:"github_client_secret" flag:"github-client-secret"`
+		StatusToken  string `env:"github_status_token" flag:"github-status-token"`
+	}
+
+	GitLab struct {
+		URL         string `env:"gitlab_url" flag:"gitlab-url" default:"https://gitlab.com"`
+		StatusToken string `env:"gitlab_status_token" flag:"gitlab-status-token"`
 	}
 
 	Papertrail struct {
@@ -29,13 +54,38 @@ type Config struct {
 		APIToken string `env:"PUSHOVER_APITOKEN" flag:"pushover-token"`
 	}
 
+	Matrix struct {
+		HomeserverURL string `env:"matrix_homeserver_url" flag:"matrix-homeserver-url" default:"https://matrix.org"`
+		AccessToken   string `env:"matrix_access_token" flag:"matrix-access-token"`
+	}
+
+	Telegram struct {
+		APIURL   string `env:"telegram_api_url" flag:"telegram-api-url" default:"https://api.telegram.org"`
+		BotToken string `env:"telegram_bot_token" flag:"telegram-bot-token"`
+	}
+
 	BuildImage struct {
 		ImageName     string `env:"BUILD_IMAGE" flag:"build-image"`
 		GPGDecryptKey string `env:"GPG_DECRYPT_KEY" flag:"gpg-decrypt-key"`
 	}
 
+	Mail struct {
+		Transport   string `env:"mail_transport" flag:"mail-transport" default:"mailgun"`
+		Sender      string `env:"mail_sender" flag:"mail-sender" default:"GoBuilder.me <help@gobuilder.me>"`
+		MaildirPath string `env:"mail_maildir_path" flag:"mail-maildir-path" default:"Maildir"`
+	}
+
 	MailGun struct {
 		MailGunAPIKey string `flag:"mailgun-key"`
+		Domain        string `env:"mailgun_domain" flag:"mailgun-domain" default:"gobuilder.me"`
+	}
+
+	SMTP struct {
+		Host     string `env:"smtp_host" flag:"smtp-host"`
+		Port     int    `env:"smtp_port" flag:"smtp-port" default:"587"`
+		Username string `env:"smtp_username" flag:"smtp-username"`
+		Password string `env:"smtp_password" flag:"smtp-password"`
+		StartTLS bool   `env:"smtp_starttls" flag:"smtp-starttls" default:"true"`
 	}
 }
 
@@ -0,0 +1,57 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// This block contains the supported types of fetch credentials
+const (
+	TypeSSHKey = "ssh_key"
+	TypeToken  = "token"
+)
+
+// FetchCredentials contain a deploy key or access token used to fetch
+// the code of a private repository. The Secret is stored encrypted using
+// the encryption key of the source repository.
+type FetchCredentials struct {
+	Type   string `json:"type"`
+	Secret string `json:"secret"`
+}
+
+// IsValidType checks whether the type of credentials is supported
+func IsValidType(t string) bool {
+	return t == TypeSSHKey || t == TypeToken
+}
+
+// SourceRepository reduces an import path to the repository containing
+// it (e.g. "github.com/Luzifer/gobuilder/cmd/starter" to
+// "github.com/Luzifer/gobuilder") as credentials are bound to the source
+func SourceRepository(repo string) string {
+	parts := strings.SplitN(repo, "/", 4)
+	if len(parts) < 3 {
+		return repo
+	}
+	return strings.Join(parts[0:3], "/")
+}
+
+// RedisKey returns the key the credentials for the repository are stored at
+func RedisKey(repo string) string {
+	return fmt.Sprintf("project::%s::fetch-credentials", SourceRepository(repo))
+}
+
+// ToString creates a JSON representation to store in Redis
+func (f *FetchCredentials) ToString() (string, error) {
+	b, err := json.Marshal(f)
+	return string(b), err
+}
+
+// FromString reads a representation created by ToString
+func FromString(s string) (*FetchCredentials, error) {
+	tmp := &FetchCredentials{}
+	if err := json.Unmarshal([]byte(s), tmp); err != nil {
+		return nil, err
+	}
+	return tmp, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const csrfSessionKey = "csrf_token"
+
+// csrfToken returns the CSRF token of the session and creates it if the
+// session has none. The session needs to be saved afterwards.
+func csrfToken(sess *sessions.Session) string {
+	if token, ok := sess.Values[csrfSessionKey].(string); ok && token != "" {
+		return token
+	}
+
+	token := hex.EncodeToString(securecookie.GenerateRandomKey(32))
+	sess.Values[csrfSessionKey] = token
+	return token
+}
+
+// checkCSRF ensures forms submitted using the session cookie carry the
+// CSRF token of the session. Requests authenticated using an API token
+// can't be forged by other sites and are not checked. If the check fails
+// a 403 is written and false is returned.
+func checkCSRF(res http.ResponseWriter, r *http.Request) bool {
+	if getBearerToken(r) != "" {
+		return true
+	}
+
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	expected, _ := sess.Values[csrfSessionKey].(string)
+	given := r.FormValue(csrfSessionKey)
+
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(given)) != 1 {
+		http.Error(res, "Invalid or missing CSRF token, please reload the page and try again.", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const dashboardCacheTime = 300
+
+type githubUserRepo struct {
+	FullName string `json:"full_name"`
+	Language string `json:"language"`
+	Private  bool   `json:"private"`
+}
+
+type dashboardRepo struct {
+	Repo        string
+	Private     bool
+	Enabled     bool
+	LastBuild   time.Time
+	BuildStatus string
+}
+
+// getGithubUserRepos lists the repositories of the logged in user having
+// Go as their main language
+func getGithubUserRepos(r *http.Request) ([]githubUserRepo, error) {
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	token, ok := sess.Values["access_token"].(string)
+	if !ok || token == "" {
+		return nil, fmt.Errorf("Not logged in with GitHub")
+	}
+
+	cacheKey := fmt.Sprintf("user::%s::github-repos", getGithubUsername(r))
+	if cached, err := redisClient.Get(cacheKey); err == nil && len(cached) > 0 {
+		repos := []githubUserRepo{}
+		if err := json.Unmarshal(cached, &repos); err == nil {
+			return repos, nil
+		}
+	}
+
+	repos := []githubUserRepo{}
+	for page := 1; page <= 10; page++ {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("https://api.github.com/user/repos?per_page=100&page=%d", page), nil)
+		req.Header.Set("Authorization", "token "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GitHub Status %d", resp.StatusCode)
+		}
+
+		pageRepos := []githubUserRepo{}
+		err = json.NewDecoder(resp.Body).Decode(&pageRepos)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, repo := range pageRepos {
+			if repo.Language == "Go" {
+				repos = append(repos, repo)
+			}
+		}
+
+		if len(pageRepos) < 100 {
+			break
+		}
+	}
+
+	if data, err := json.Marshal(repos); err == nil {
+		redisClient.Set(cacheKey, string(data), dashboardCacheTime, 0, false, false)
+	}
+
+	return repos, nil
+}
+
+// getDashboard collects the GoBuilder status of the users repositories
+func getDashboard(r *http.Request) []dashboardRepo {
+	ghRepos, err := getGithubUserRepos(r)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"user":  getGithubUsername(r),
+		}).Error("Unable to fetch repositories for dashboard")
+		return nil
+	}
+
+	dashboard := []dashboardRepo{}
+	for _, ghRepo := range ghRepos {
+		repo := fmt.Sprintf("github.com/%s", ghRepo.FullName)
+		entry := dashboardRepo{
+			Repo:    repo,
+			Private: ghRepo.Private,
+		}
+
+		if enabled, err := redisClient.Get(webhookEnabledKey(repo)); err == nil {
+			entry.Enabled = string(enabled) == "true"
+		}
+
+		if status, err := redisClient.Get(fmt.Sprintf("project::%s::build-status", repo)); err == nil {
+			entry.BuildStatus = string(status)
+		}
+
+		if commits, err := redisClient.ZRevRangeByScore(fmt.Sprintf("project::%s::built-commits", repo), "+inf", "-inf", true, true, 0, 1); err == nil && len(commits) == 2 {
+			if ts, err := strconv.ParseFloat(commits[1], 64); err == nil {
+				entry.LastBuild = time.Unix(int64(ts), 0)
+			}
+		}
+
+		dashboard = append(dashboard, entry)
+	}
+
+	return dashboard
+}
+
+func handleDashboardToggle(res http.ResponseWriter, r *http.Request) {
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	repo := r.FormValue("repository")
+
+	if getGithubUsername(r) == "" {
+		http.Redirect(res, r, "/ghlogin", http.StatusFound)
+		return
+	}
+
+	if getRepoAccessLevel(r, repo) != repoAccessAdmin {
+		sess.AddFlash("You need admin access to the repository to enable GoBuilder.", "alert_error")
+		sess.Save(r, res)
+		http.Redirect(res, r, "/", http.StatusFound)
+		return
+	}
+
+	if !checkCSRF(res, r) {
+		return
+	}
+
+	enabled, _ := redisClient.Get(webhookEnabledKey(repo))
+	if string(enabled) == "true" {
+		if err := removeGithubWebhook(res, r, repo); err != nil {
+			sess.AddFlash("Could not remove the hook from your repository.", "alert_error")
+		} else {
+			sess.AddFlash(fmt.Sprintf("GoBuilder has been disabled for %s.", repo), "alert_success")
+		}
+		sess.Save(r, res)
+		http.Redirect(res, r, "/", http.StatusFound)
+		return
+	}
+
+	if err := addGithubWebhook(res, r, repo); err != nil {
+		http.Redirect(res, r, "/", http.StatusFound)
+		return
+	}
+
+	if err := sendToQueue(repo, ""); err != nil {
+		sess.AddFlash("An unknown error occured while queueing the repository.", "alert_error")
+		sess.Save(r, res)
+		http.Redirect(res, r, "/", http.StatusFound)
+		return
+	}
+
+	sess.AddFlash(flashContext{
+		"success": "GoBuilder has been enabled and your first build has been submitted.",
+		"repo":    repo,
+	}, "context")
+	sess.Save(r, res)
+	http.Redirect(res, r, "/", http.StatusFound)
+}
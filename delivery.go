@@ -1,9 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"path"
+	"strings"
 	"time"
 
+	"github.com/Luzifer/gobuilder/assetstore"
+	"github.com/Luzifer/gobuilder/builddb"
+	"github.com/Luzifer/gobuilder/downloads"
+	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 )
 
@@ -15,7 +23,92 @@ func handlerDeliverFileFromS3(res http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !checkRepoAccess(res, r, path.Dir(params["file"])) {
+		return
+	}
+
 	t := time.Now()
 	t = t.Add(1 * time.Hour)
-	http.Redirect(res, r, s3Bucket.SignedURL(params["file"], t), http.StatusFound)
+
+	// Label files are references to the content-addressed objects
+	key, err := assetstore.Resolve(redisClient, params["file"])
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"file":  params["file"],
+			"error": err,
+		}).Error("Unable to resolve asset")
+		http.Error(res, "Unable to resolve asset", http.StatusInternalServerError)
+		return
+	}
+
+	// Only downloads of assets actually built are counted
+	label, isLabelFile := downloadLabel(path.Dir(params["file"]), path.Base(params["file"]))
+	if key != params["file"] || isLabelFile {
+		recordDownload(params["file"], label)
+	}
+
+	if key == params["file"] {
+		http.Redirect(res, r, s3Bucket.SignedURL(key, t), http.StatusFound)
+		return
+	}
+
+	url, err := assetstore.SignedURL(s3Bucket, key, path.Base(params["file"]), t)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"file":  params["file"],
+			"error": err,
+		}).Error("Unable to sign asset URL")
+		http.Error(res, "Unable to resolve asset", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(res, r, url, http.StatusFound)
+}
+
+// recordDownload counts the download of the file for the label and
+// platform of the asset it belongs to
+func recordDownload(file, label string) {
+	repo, name := path.Dir(file), path.Base(file)
+	platform := downloads.Platform(name)
+
+	metricPlatform := platform
+	if !downloads.IsKnownPlatform(metricPlatform) {
+		metricPlatform = "other"
+	}
+	metricDownloads.WithLabelValues(metricPlatform).Inc()
+
+	if err := downloads.Record(redisClient, repo, label, platform, time.Now()); err != nil {
+		log.WithFields(logrus.Fields{
+			"file":  file,
+			"error": err,
+		}).Error("Unable to record download")
+	}
+}
+
+// downloadLabel looks up the label the file was built for and reports
+// whether it was found. Files of former builds do not belong to a label
+// anymore.
+func downloadLabel(repo, name string) (string, bool) {
+	raw, err := redisClient.Get(fmt.Sprintf("project::%s::builddb", repo))
+	if err != nil || len(raw) == 0 {
+		return "", false
+	}
+
+	buildDB := builddb.BuildDB{}
+	if err := json.Unmarshal(raw, &buildDB); err != nil {
+		return "", false
+	}
+
+	for label, branch := range buildDB {
+		if name == fmt.Sprintf("%s_README.md", label) {
+			return label, true
+		}
+		for _, a := range branch.Assets {
+			binary := strings.TrimSuffix(a.FileName, ".zip")
+			if name == a.FileName || name == binary || name == binary+".exe" {
+				return label, true
+			}
+		}
+	}
+
+	return "", false
 }
@@ -0,0 +1,241 @@
+package downloads
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuyu/goredis"
+)
+
+const (
+	// DailyRetention is the number of days the daily buckets are kept
+	DailyRetention = 90
+
+	dateFormat = "2006-01-02"
+)
+
+// Stats summarizes the downloads of a repository
+type Stats struct {
+	// Total is the number of all downloads ever counted
+	Total int64 `json:"total"`
+	// Labels and Platforms count all downloads ever counted per label
+	// and per platform (os-arch), Assets per label and platform
+	Labels    map[string]int64            `json:"labels"`
+	Platforms map[string]int64            `json:"platforms"`
+	Assets    map[string]map[string]int64 `json:"assets"`
+	// Daily lists the downloads of the requested days oldest first
+	Daily []Day `json:"daily"`
+}
+
+// Day is the number of downloads of a single day
+type Day struct {
+	Date      string `json:"date"`
+	Downloads int64  `json:"downloads"`
+}
+
+// RecentTotal sums up the downloads of the requested days
+func (s Stats) RecentTotal() int64 {
+	var sum int64
+	for _, d := range s.Daily {
+		sum += d.Downloads
+	}
+	return sum
+}
+
+// LabelDownloads returns the number of downloads of the label
+func (s Stats) LabelDownloads(label string) int64 {
+	return s.Labels[label]
+}
+
+// AssetDownloads returns the number of downloads of the asset of the label
+// identified by its file name
+func (s Stats) AssetDownloads(label, fileName string) int64 {
+	return s.Assets[label][Platform(fileName)]
+}
+
+// DailyMax returns the highest number of downloads of a single day
+func (s Stats) DailyMax() int64 {
+	var max int64
+	for _, d := range s.Daily {
+		if d.Downloads > max {
+			max = d.Downloads
+		}
+	}
+	return max
+}
+
+func totalKey(repo string) string {
+	return fmt.Sprintf("project::%s::downloads", repo)
+}
+
+func dayKey(repo string, t time.Time) string {
+	return fmt.Sprintf("%s::%s", totalKey(repo), t.UTC().Format(dateFormat))
+}
+
+// Record counts a download of the asset of the label built for the
+// platform. Label and platform are empty for files not belonging to a
+// known asset. Nothing about the client is stored.
+func Record(redisClient *goredis.Redis, repo, label, platform string, t time.Time) error {
+	fields := []string{"total"}
+	if label != "" {
+		fields = append(fields, "label::"+label)
+	}
+	if platform != "" {
+		fields = append(fields, "platform::"+platform)
+	}
+	if label != "" && platform != "" {
+		fields = append(fields, fmt.Sprintf("asset::%s::%s", label, platform))
+	}
+
+	day := dayKey(repo, t)
+	commands := [][]interface{}{{"MULTI"}}
+	for _, key := range []string{totalKey(repo), day} {
+		for _, field := range fields {
+			commands = append(commands, []interface{}{"HINCRBY", key, field, 1})
+		}
+	}
+	commands = append(commands,
+		[]interface{}{"EXPIRE", day, (DailyRetention + 1) * 24 * 3600},
+		[]interface{}{"EXEC"},
+	)
+
+	return execPipelined(redisClient, commands)
+}
+
+// execPipelined sends all commands in a single round-trip and returns the
+// first error reported for any of them
+func execPipelined(redisClient *goredis.Redis, commands [][]interface{}) error {
+	p, err := redisClient.Pipelining()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	for _, command := range commands {
+		if err := p.Command(command...); err != nil {
+			// Read the replies of the commands already sent to not leave
+			// them on the connection returned to the pool
+			p.ReceiveAll()
+			return err
+		}
+	}
+
+	replies, err := p.ReceiveAll()
+	if err != nil {
+		return err
+	}
+
+	for _, rp := range replies {
+		if rp.Type == goredis.ErrorReply {
+			return errors.New(rp.Error)
+		}
+		for _, sub := range rp.Multi {
+			if sub != nil && sub.Type == goredis.ErrorReply {
+				return errors.New(sub.Error)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get returns the statistics of the repository including the daily
+// downloads of the last days
+func Get(redisClient *goredis.Redis, repo string, days int) (Stats, error) {
+	stats := Stats{
+		Labels:    map[string]int64{},
+		Platforms: map[string]int64{},
+		Assets:    map[string]map[string]int64{},
+		Daily:     []Day{},
+	}
+
+	total, err := redisClient.HGetAll(totalKey(repo))
+	if err != nil {
+		return stats, err
+	}
+
+	for field, value := range total {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		parts := strings.Split(field, "::")
+		switch {
+		case field == "total":
+			stats.Total = n
+		case parts[0] == "label" && len(parts) == 2:
+			stats.Labels[parts[1]] = n
+		case parts[0] == "platform" && len(parts) == 2:
+			stats.Platforms[parts[1]] = n
+		case parts[0] == "asset" && len(parts) == 3:
+			if _, ok := stats.Assets[parts[1]]; !ok {
+				stats.Assets[parts[1]] = map[string]int64{}
+			}
+			stats.Assets[parts[1]][parts[2]] = n
+		}
+	}
+
+	if days > DailyRetention {
+		days = DailyRetention
+	}
+
+	now := time.Now()
+	for i := days - 1; i >= 0; i-- {
+		t := now.AddDate(0, 0, -i)
+
+		raw, err := redisClient.HGet(dayKey(repo, t), "total")
+		if err != nil {
+			return stats, err
+		}
+
+		n, _ := strconv.ParseInt(string(raw), 10, 64)
+		stats.Daily = append(stats.Daily, Day{
+			Date:      t.UTC().Format(dateFormat),
+			Downloads: n,
+		})
+	}
+
+	return stats, nil
+}
+
+// Platform extracts the platform (os-arch) from the file name of a zip
+// or binary asset ("product_label_linux-amd64.zip") and returns an empty
+// string for other files
+func Platform(name string) string {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".zip"), ".exe")
+
+	idx := strings.LastIndex(name, "_")
+	if idx < 0 {
+		return ""
+	}
+
+	platform := name[idx+1:]
+	if strings.Count(platform, "-") != 1 || strings.Contains(platform, ".") {
+		return ""
+	}
+	return platform
+}
+
+var (
+	knownOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true,
+		"illumos": true, "ios": true, "js": true, "linux": true, "nacl": true, "netbsd": true,
+		"openbsd": true, "plan9": true, "solaris": true, "wasip1": true, "windows": true,
+	}
+	knownArch = map[string]bool{
+		"386": true, "amd64": true, "amd64p32": true, "arm": true, "arm64": true,
+		"loong64": true, "mips": true, "mipsle": true, "mips64": true, "mips64le": true,
+		"ppc64": true, "ppc64le": true, "riscv64": true, "s390x": true, "wasm": true,
+	}
+)
+
+// IsKnownPlatform reports whether the platform is a combination of a
+// GOOS and a GOARCH known to Go
+func IsKnownPlatform(platform string) bool {
+	parts := strings.Split(platform, "-")
+	return len(parts) == 2 && knownOS[parts[0]] && knownArch[parts[1]]
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/gobuilder/builddb"
+	"github.com/Luzifer/gobuilder/buildjob"
+	"github.com/Luzifer/gobuilder/retention"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+)
+
+const feedMaxEntries = 30
+
+type atomFeed struct {
+	XMLName xml.Name       `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string         `xml:"id"`
+	Title   string         `xml:"title"`
+	Updated xmlSitemapTime `xml:"updated"`
+	Author  atomAuthor     `xml:"author"`
+	Links   []atomLink     `xml:"link"`
+	Entries []atomEntry    `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string         `xml:"id"`
+	Title   string         `xml:"title"`
+	Updated xmlSitemapTime `xml:"updated"`
+	Links   []atomLink     `xml:"link"`
+	Content atomContent    `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// absoluteURL prefixes the path with the public URL of the frontend
+func absoluteURL(path string) string {
+	base := strings.TrimRight(cfg.BaseURL, "/")
+	if base == "" {
+		base = "https://gobuilder.me"
+	}
+	return base + path
+}
+
+func newAtomFeed(r *http.Request, title, alternate string) *atomFeed {
+	return &atomFeed{
+		ID:      absoluteURL(r.URL.Path),
+		Title:   title,
+		Updated: xmlSitemapTime{Time: time.Now()},
+		Author:  atomAuthor{Name: "GoBuilder"},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: absoluteURL(r.URL.Path)},
+			{Rel: "alternate", Type: "text/html", Href: absoluteURL(alternate)},
+		},
+	}
+}
+
+func writeAtomFeed(res http.ResponseWriter, r *http.Request, feed *atomFeed) {
+	if len(feed.Entries) > 0 {
+		// Entries are sorted newest first
+		feed.Updated = feed.Entries[0].Updated
+	}
+
+	body, err := xml.Marshal(feed)
+	if err != nil {
+		http.Error(res, "An error ocurred", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Cache-Control", "no-cache")
+	writeWithETag(res, r, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+// checkFeedAccess hides feeds of blocked repositories and of private
+// repositories the client has no access to
+func checkFeedAccess(res http.ResponseWriter, r *http.Request, repo string) bool {
+	if blocked, _ := blockedRepos.IsBlocked(repo); blocked {
+		http.Error(res, "Not found", http.StatusNotFound)
+		return false
+	}
+	return checkRepoAccess(res, r, repo)
+}
+
+// handleBuildsFeed lists the builds of a repository with their result
+func handleBuildsFeed(res http.ResponseWriter, r *http.Request) {
+	repo := mux.Vars(r)["repo"]
+	if !checkFeedAccess(res, r, repo) {
+		return
+	}
+
+	logs, err := redisClient.ZRevRange(fmt.Sprintf("project::%s::logs", repo), 0, feedMaxEntries-1, false)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  repo,
+		}).Error("Failed to read build logs for feed")
+		http.Error(res, "An error ocurred", http.StatusInternalServerError)
+		return
+	}
+
+	feed := newAtomFeed(r, fmt.Sprintf("Builds of %s", repo), "/"+repo)
+	for _, v := range logs {
+		l, err := buildjob.LogFromString(v)
+		if err != nil {
+			continue // There are old build logs which can't be parsed
+		}
+
+		status := "failed"
+		if l.Success {
+			status = "succeeded"
+		}
+
+		commit := l.Commit
+		if commit == "" {
+			commit = "unknown"
+		}
+
+		logURL := absoluteURL(fmt.Sprintf("/%s/log/%s", repo, l.ID))
+		content := []string{
+			fmt.Sprintf("<p>Build of commit <code>%s</code> %s.</p>", html.EscapeString(commit), status),
+		}
+		if len(l.Platforms) > 0 {
+			platforms := []string{}
+			for _, p := range l.Platforms {
+				result := "ok"
+				if !p.Success {
+					result = "failed"
+				}
+				platforms = append(platforms, fmt.Sprintf("<li>%s: %s</li>", html.EscapeString(p.Platform), result))
+			}
+			content = append(content, fmt.Sprintf("<ul>%s</ul>", strings.Join(platforms, "")))
+		}
+		content = append(content, fmt.Sprintf(`<p><a href="%s">Build log</a></p>`, html.EscapeString(logURL)))
+
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      logURL,
+			Title:   fmt.Sprintf("Build of %s (%s) %s", repo, commit, status),
+			Updated: xmlSitemapTime{Time: l.Time},
+			Links:   []atomLink{{Rel: "alternate", Type: "text/html", Href: logURL}},
+			Content: atomContent{Type: "html", Body: strings.Join(content, "\n")},
+		})
+	}
+
+	writeAtomFeed(res, r, feed)
+}
+
+// handleReleasesFeed lists the builds of the tags of a repository with
+// links to their assets
+func handleReleasesFeed(res http.ResponseWriter, r *http.Request) {
+	repo := mux.Vars(r)["repo"]
+	if !checkFeedAccess(res, r, repo) {
+		return
+	}
+
+	tags, err := redisClient.SMembers(retention.KnownTagsKey(repo))
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  repo,
+		}).Error("Failed to read tags for feed")
+		http.Error(res, "An error ocurred", http.StatusInternalServerError)
+		return
+	}
+
+	releases := []*builddb.Build{}
+	for _, tag := range tags {
+		b, err := builddb.CurrentBuild(redisClient, repo, tag)
+		if err != nil || b == nil {
+			// Tags created before their repository was built have no build
+			continue
+		}
+		releases = append(releases, b)
+	}
+	sort.Sort(sort.Reverse(buildsByDate(releases)))
+	if len(releases) > feedMaxEntries {
+		releases = releases[:feedMaxEntries]
+	}
+
+	feed := newAtomFeed(r, fmt.Sprintf("Releases of %s", repo), "/"+repo)
+	for _, b := range releases {
+		pageURL := absoluteURL(fmt.Sprintf("/%s?branch=%s", repo, url.QueryEscape(b.Label)))
+
+		assets := []string{}
+		for _, a := range b.Assets {
+			path := a.Path
+			if path == "" {
+				path = fmt.Sprintf("%s/%s", repo, a.FileName)
+			}
+			assets = append(assets, fmt.Sprintf(`<li><a href="%s">%s</a> (SHA256 <code>%s</code>)</li>`,
+				html.EscapeString(absoluteURL("/get/"+path)), html.EscapeString(a.FileName), a.SHA256))
+		}
+
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      absoluteURL(fmt.Sprintf("/%s/releases/%s", repo, b.Label)),
+			Title:   fmt.Sprintf("%s %s", repo, b.Label),
+			Updated: xmlSitemapTime{Time: b.BuildDate},
+			Links:   []atomLink{{Rel: "alternate", Type: "text/html", Href: pageURL}},
+			Content: atomContent{Type: "html", Body: fmt.Sprintf("<p>Built from commit <code>%s</code> using %s.</p>\n<ul>%s</ul>",
+				html.EscapeString(b.Commit), html.EscapeString(b.GoVersion), strings.Join(assets, ""))},
+		})
+	}
+
+	writeAtomFeed(res, r, feed)
+}
+
+// handleGlobalFeed lists the repositories built last
+func handleGlobalFeed(res http.ResponseWriter, r *http.Request) {
+	l, err := redisClient.ZRevRange("last-builds", 0, 2*feedMaxEntries-1, true)
+	if err != nil {
+		http.Error(res, "An error ocurred", http.StatusInternalServerError)
+		return
+	}
+
+	feed := newAtomFeed(r, "Latest builds on GoBuilder", "/")
+	for i := 0; i+1 < len(l) && len(feed.Entries) < feedMaxEntries; i += 2 {
+		repo := l[i]
+
+		if blocked, _ := blockedRepos.IsBlocked(repo); blocked || isPrivateRepo(repo) {
+			continue
+		}
+
+		t, err := strconv.ParseInt(l[i+1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		status, _ := redisClient.Get(fmt.Sprintf("project::%s::build-status", repo))
+		pageURL := absoluteURL("/" + repo)
+
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      fmt.Sprintf("%s#%d", pageURL, t),
+			Title:   fmt.Sprintf("%s was built", repo),
+			Updated: xmlSitemapTime{Time: time.Unix(t, 0)},
+			Links:   []atomLink{{Rel: "alternate", Type: "text/html", Href: pageURL}},
+			Content: atomContent{Type: "html", Body: fmt.Sprintf(`<p>Build status: %s</p><p><a href="%s">Downloads</a></p>`, html.EscapeString(string(status)), html.EscapeString(pageURL))},
+		})
+	}
+
+	writeAtomFeed(res, r, feed)
+}
+
+type buildsByDate []*builddb.Build
+
+func (b buildsByDate) Len() int           { return len(b) }
+func (b buildsByDate) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b buildsByDate) Less(i, j int) bool { return b[i].BuildDate.Before(b[j].BuildDate) }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -16,6 +17,7 @@ import (
 )
 
 type githubHook struct {
+	ID     int64            `json:"id,omitempty"`
 	Name   string           `json:"name"`
 	Active bool             `json:"active"`
 	Events []string         `json:"events"`
@@ -24,18 +26,35 @@ type githubHook struct {
 type githubHookConfig struct {
 	URL         string `json:"url"`
 	ContentType string `json:"content_type"`
+	Secret      string `json:"secret,omitempty"`
 }
 
+// githubScopes are requested on every login
+var githubScopes = []string{
+	"write:repo_hook", // We want to write the webhook to execute a gobuilder build
+}
+
+// githubPrivateScope is only requested from users enabling a private
+// repository as it grants access to all of their private code
+const githubPrivateScope = "repo"
+
 func handleOauthGithubInit(res http.ResponseWriter, r *http.Request) {
 	sess, _ := sessionStore.Get(r, "GoBuilderSession")
 
-	scopes := strings.Join([]string{
-		"write:repo_hook", // We want to write the webhook to execute a gobuilder build
-	}, ",")
-
 	if code := r.URL.Query().Get("code"); code != "" {
 		state := sess.Values["gh_oauth_state"].(string)
 
+		requiredScopes := githubScopes
+		if private, _ := sess.Values["gh_oauth_private"].(bool); private {
+			requiredScopes = append([]string{githubPrivateScope}, githubScopes...)
+		}
+		returnTo, _ := sess.Values["gh_oauth_return"].(string)
+		if returnTo == "" {
+			returnTo = "/"
+		}
+		delete(sess.Values, "gh_oauth_private")
+		delete(sess.Values, "gh_oauth_return")
+
 		if r.URL.Query().Get("state") != state {
 			sess.AddFlash("Something went wrong while authenticating you. Please try again.", "alert_error")
 			sess.Save(r, res)
@@ -61,23 +80,43 @@ func handleOauthGithubInit(res http.ResponseWriter, r *http.Request) {
 		body, _ := ioutil.ReadAll(resp.Body)
 		accessInformation, _ := url.ParseQuery(string(body))
 
-		if accessInformation.Get("scope") != scopes {
+		if !hasAllScopes(accessInformation.Get("scope"), requiredScopes) {
 			sess.AddFlash("You denied some access rights. Unable to work that way.", "alert_error")
 			sess.Save(r, res)
 			http.Redirect(res, r, "/", http.StatusFound)
 			return
 		}
 
-		sess.Values["access_token"] = accessInformation.Get("access_token")
+		token := accessInformation.Get("access_token")
+		sess.Values["access_token"] = token
+		sess.Values["gh_scopes"] = accessInformation.Get("scope")
+		if login := fetchGithubUsername(token); login != "" {
+			sess.Values["gh_login"] = login
+			if err := registerUser(login); err != nil {
+				log.WithFields(logrus.Fields{
+					"error": err,
+					"user":  login,
+				}).Error("Unable to register user")
+			}
+		}
 		sess.Save(r, res)
-		http.Redirect(res, r, "/", http.StatusFound)
+		http.Redirect(res, r, returnTo, http.StatusFound)
 		return
 	}
 
+	scopes := githubScopes
+	if r.URL.Query().Get("private") == "true" {
+		scopes = append([]string{githubPrivateScope}, githubScopes...)
+		sess.Values["gh_oauth_private"] = true
+	}
+	if returnTo := r.URL.Query().Get("return"); strings.HasPrefix(returnTo, "/") && !strings.HasPrefix(returnTo, "//") {
+		sess.Values["gh_oauth_return"] = returnTo
+	}
+
 	query := url.Values{}
 	redirURL, _ := url.Parse("https://github.com/login/oauth/authorize")
 	query.Set("client_id", cfg.GitHub.ClientID)
-	query.Set("scope", scopes)
+	query.Set("scope", strings.Join(scopes, ","))
 
 	u := uuid.NewV4().String()
 	sess.Values["gh_oauth_state"] = u
@@ -88,9 +127,39 @@ func handleOauthGithubInit(res http.ResponseWriter, r *http.Request) {
 	http.Redirect(res, r, redirURL.String(), http.StatusFound)
 }
 
+func hasAllScopes(granted string, required []string) bool {
+	grantedScopes := map[string]bool{}
+	for _, s := range strings.Split(granted, ",") {
+		grantedScopes[strings.TrimSpace(s)] = true
+	}
+
+	for _, s := range required {
+		if !grantedScopes[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPrivateRepoScope checks whether the user granted access to private
+// repositories on login
+func hasPrivateRepoScope(r *http.Request) bool {
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	granted, _ := sess.Values["gh_scopes"].(string)
+	return hasAllScopes(granted, []string{githubPrivateScope})
+}
+
+// privateRepoLoginURL requests access to private repositories and returns
+// to the page of the repository afterwards
+func privateRepoLoginURL(repo string) string {
+	return "/ghlogin?" + url.Values{"private": {"true"}, "return": {"/" + repo}}.Encode()
+}
+
 func handleOauthGithubLogout(res http.ResponseWriter, r *http.Request) {
 	sess, _ := sessionStore.Get(r, "GoBuilderSession")
 	delete(sess.Values, "access_token")
+	delete(sess.Values, "gh_login")
+	delete(sess.Values, "gh_scopes")
 	sess.Save(r, res)
 	http.Redirect(res, r, "/", http.StatusFound)
 }
@@ -98,11 +167,20 @@ func handleOauthGithubLogout(res http.ResponseWriter, r *http.Request) {
 func getGithubUsername(r *http.Request) string {
 	sess, _ := sessionStore.Get(r, "GoBuilderSession")
 
-	if _, ok := sess.Values["access_token"].(string); !ok {
+	if login, ok := sess.Values["gh_login"].(string); ok && login != "" {
+		return login
+	}
+
+	token, ok := sess.Values["access_token"].(string)
+	if !ok {
 		return ""
 	}
 
-	resp, err := http.Get("https://api.github.com/user?access_token=" + sess.Values["access_token"].(string))
+	return fetchGithubUsername(token)
+}
+
+func fetchGithubUsername(token string) string {
+	resp, err := http.Get("https://api.github.com/user?access_token=" + token)
 	if err != nil {
 		log.WithField("error", err.Error()).Error("Unable to fetch GitHub username")
 		return ""
@@ -122,18 +200,27 @@ func getGithubUsername(r *http.Request) string {
 	return d.Login
 }
 
-func addGithubWebhook(res http.ResponseWriter, r *http.Request, repo string) {
+func webhookEnabledKey(repo string) string {
+	return fmt.Sprintf("project::%s::webhook", repo)
+}
+
+func githubHookURL() string {
+	return strings.TrimRight(cfg.BaseURL, "/") + "/api/v1/webhook/github"
+}
+
+// findGithubWebhook returns owner and name of the repository and the ID
+// of the GoBuilder webhook in it or 0 if there is none
+func findGithubWebhook(res http.ResponseWriter, r *http.Request, repo string) (string, string, int64, error) {
 	sess, _ := sessionStore.Get(r, "GoBuilderSession")
-	hookURL := strings.TrimRight(cfg.BaseURL, "/") + "/api/v1/webhook/github"
 
 	if token, ok := sess.Values["access_token"].(string); !ok || len(token) == 0 {
-		return
+		return "", "", 0, errors.New("Not logged in with GitHub")
 	}
 
 	re := regexp.MustCompile("^github.com/([^/]+)/([^/]+)")
 	if !re.MatchString(repo) {
 		log.WithField("repo", repo).Error("Tried to add webhook to non-github-repo")
-		return
+		return "", "", 0, errors.New("Not a GitHub repository")
 	}
 
 	matches := re.FindStringSubmatch(repo)
@@ -148,32 +235,53 @@ func addGithubWebhook(res http.ResponseWriter, r *http.Request, repo string) {
 		}).Error("Unable to fetch hooks for Repo")
 		sess.AddFlash("Could not access the hooks for your repository.", "alert_error")
 		sess.Save(r, res)
-		return
+		return "", "", 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		log.Errorf("GitHub Status %d", resp.StatusCode)
-		return
+		return "", "", 0, fmt.Errorf("GitHub Status %d", resp.StatusCode)
 	}
 
 	t := []githubHook{}
 	json.NewDecoder(resp.Body).Decode(&t)
 
 	for _, v := range t {
-		if v.Config.URL == hookURL {
-			// We found our hook, we're happy
-			return
+		if v.Config.URL == githubHookURL() {
+			return owner, repomatch, v.ID, nil
 		}
 	}
 
+	return owner, repomatch, 0, nil
+}
+
+func addGithubWebhook(res http.ResponseWriter, r *http.Request, repo string) error {
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+
+	owner, repomatch, hookID, err := findGithubWebhook(res, r, repo)
+	if err != nil {
+		return err
+	}
+
+	if hookID != 0 {
+		// We found our hook, we're happy
+		return redisClient.Set(webhookEnabledKey(repo), "true", 0, 0, false, false)
+	}
+
+	secret, err := ensureWebhookSecret(repo)
+	if err != nil {
+		return err
+	}
+
 	hook := githubHook{
 		Name:   "web",
 		Active: true,
-		Events: []string{"push"},
+		Events: []string{"push", "pull_request"},
 		Config: githubHookConfig{
-			URL:         hookURL,
+			URL:         githubHookURL(),
 			ContentType: "json",
+			Secret:      secret,
 		},
 	}
 	body, _ := json.Marshal(hook)
@@ -187,7 +295,7 @@ func addGithubWebhook(res http.ResponseWriter, r *http.Request, repo string) {
 		}).Error("Unable to set hook for Repo")
 		sess.AddFlash("Could not set the hook for your repository.", "alert_error")
 		sess.Save(r, res)
-		return
+		return err
 	}
 	defer setResp.Body.Close()
 
@@ -199,5 +307,38 @@ func addGithubWebhook(res http.ResponseWriter, r *http.Request, repo string) {
 		}).Error("Unable to set hook for Repo")
 		sess.AddFlash("Could not set the hook for your repository.", "alert_error")
 		sess.Save(r, res)
+		return fmt.Errorf("GitHub Status %d", setResp.StatusCode)
+	}
+
+	return redisClient.Set(webhookEnabledKey(repo), "true", 0, 0, false, false)
+}
+
+func removeGithubWebhook(res http.ResponseWriter, r *http.Request, repo string) error {
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+
+	owner, repomatch, hookID, err := findGithubWebhook(res, r, repo)
+	if err != nil {
+		return err
 	}
+
+	if hookID != 0 {
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("https://api.github.com/repos/%s/%s/hooks/%d", owner, repomatch, hookID), nil)
+		req.Header.Set("Authorization", "token "+sess.Values["access_token"].(string))
+		delResp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer delResp.Body.Close()
+
+		if delResp.StatusCode != http.StatusNoContent {
+			log.WithFields(logrus.Fields{
+				"repo":   repo,
+				"status": strconv.FormatInt(int64(delResp.StatusCode), 10),
+			}).Error("Unable to remove hook from Repo")
+			return fmt.Errorf("GitHub Status %d", delResp.StatusCode)
+		}
+	}
+
+	_, err = redisClient.Del(webhookEnabledKey(repo))
+	return err
 }
@@ -0,0 +1,112 @@
+package logstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuyu/goredis"
+)
+
+// ChunkSize is the maximum size of a single compressed chunk stored in Redis
+const ChunkSize = 256 * 1024
+
+func logKey(repo, id string) string {
+	return fmt.Sprintf("project::%s::logs::%s", repo, id)
+}
+
+func chunksKey(repo, id string) string {
+	return logKey(repo, id) + "::chunks"
+}
+
+// Write compresses the build log and stores it in chunks
+func Write(redisClient *goredis.Redis, repo, id, buildLog string) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(buildLog)); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	chunks := []string{}
+	for len(data) > 0 {
+		n := ChunkSize
+		if len(data) < n {
+			n = len(data)
+		}
+		chunks = append(chunks, string(data[:n]))
+		data = data[n:]
+	}
+
+	if _, err := redisClient.Del(chunksKey(repo, id)); err != nil {
+		return err
+	}
+	_, err := redisClient.RPush(chunksKey(repo, id), chunks...)
+	return err
+}
+
+// Read returns the build log or nil if there is no such log. Logs stored
+// as a single uncompressed string before are supported.
+func Read(redisClient *goredis.Redis, repo, id string) ([]byte, error) {
+	chunks, err := redisClient.LRange(chunksKey(repo, id), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chunks) == 0 {
+		// Fall back to the legacy storage
+		return redisClient.Get(logKey(repo, id))
+	}
+
+	gz, err := gzip.NewReader(strings.NewReader(strings.Join(chunks, "")))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	return ioutil.ReadAll(gz)
+}
+
+// Delete removes the build log in any storage format
+func Delete(redisClient *goredis.Redis, repo, id string) error {
+	_, err := redisClient.Del(chunksKey(repo, id), logKey(repo, id))
+	return err
+}
+
+// Truncate limits the size of the build log by cutting out its middle
+// part as the beginning and the end of the log are the most useful parts
+func Truncate(buildLog string, maxSize int) string {
+	if maxSize <= 0 || len(buildLog) <= maxSize {
+		return buildLog
+	}
+
+	// Back off to rune boundaries to not split multi-byte characters
+	headEnd := maxSize / 2
+	for headEnd > 0 && !utf8.RuneStart(buildLog[headEnd]) {
+		headEnd--
+	}
+	tailStart := len(buildLog) - maxSize/2
+	for tailStart < len(buildLog) && !utf8.RuneStart(buildLog[tailStart]) {
+		tailStart++
+	}
+
+	head := buildLog[:headEnd]
+	tail := buildLog[tailStart:]
+
+	// Cut at line boundaries to keep the remaining lines intact
+	if i := strings.LastIndex(head, "\n"); i > 0 {
+		head = head[:i+1]
+	}
+	if i := strings.Index(tail, "\n"); i >= 0 && i < len(tail)-1 && buildLog[tailStart-1] != '\n' {
+		tail = tail[i+1:]
+	}
+
+	return fmt.Sprintf("%s[... %d bytes of the build log were truncated ...]\n%s",
+		head, len(buildLog)-len(head)-len(tail), tail)
+}
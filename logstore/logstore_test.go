@@ -0,0 +1,69 @@
+package logstore
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateWithinLimit(t *testing.T) {
+	buildLog := "line 1\nline 2\n"
+
+	for _, maxSize := range []int{0, -1, len(buildLog), len(buildLog) + 1} {
+		if truncated := Truncate(buildLog, maxSize); truncated != buildLog {
+			t.Errorf("Log within limit %d was truncated: %q", maxSize, truncated)
+		}
+	}
+}
+
+func TestTruncateLines(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 100; i++ {
+		lines = append(lines, strings.Repeat(string('a'+rune(i%26)), 9))
+	}
+	buildLog := strings.Join(lines, "\n") + "\n"
+
+	truncated := Truncate(buildLog, 100)
+	parts := strings.Split(truncated, "[... ")
+	if len(parts) != 2 {
+		t.Fatalf("Truncated log has no marker: %q", truncated)
+	}
+	head := parts[0]
+	tail := parts[1][strings.Index(parts[1], "\n")+1:]
+
+	if !strings.HasPrefix(buildLog, head) || !strings.HasSuffix(buildLog, tail) {
+		t.Errorf("Head or tail of the log was not kept: %q", truncated)
+	}
+	if len(head) > 50 || len(tail) > 50 {
+		t.Errorf("Head (%d) or tail (%d) exceed half of the limit", len(head), len(tail))
+	}
+	if !strings.HasSuffix(head, "\n") || !strings.HasSuffix(buildLog, "\n"+tail) {
+		t.Errorf("Log was not cut at line boundaries: %q", truncated)
+	}
+	if !strings.Contains(truncated, "[... 900 bytes of the build log were truncated ...]") {
+		t.Errorf("Truncated log reports wrong size: %q", truncated)
+	}
+}
+
+func TestTruncateWithoutLines(t *testing.T) {
+	truncated := Truncate(strings.Repeat("a", 1000), 100)
+
+	if truncated != strings.Repeat("a", 50)+"[... 900 bytes of the build log were truncated ...]\n"+strings.Repeat("a", 50) {
+		t.Errorf("Unexpected truncated log: %q", truncated)
+	}
+}
+
+func TestTruncateMultiByte(t *testing.T) {
+	// Every rune takes 3 bytes so half of the limit is no rune boundary
+	buildLog := strings.Repeat("ä€", 200)
+
+	for _, maxSize := range []int{10, 11, 100, 101} {
+		truncated := Truncate(buildLog, maxSize)
+		if !utf8.ValidString(truncated) {
+			t.Errorf("Truncating to %d split a multi-byte character: %q", maxSize, truncated)
+		}
+		if len(truncated) >= len(buildLog) {
+			t.Errorf("Truncating to %d did not shorten the log", maxSize)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package logstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"regexp"
+	"strings"
+)
+
+// MaskReplacement is inserted instead of masked secrets
+const MaskReplacement = "********"
+
+// tokenPatterns match common credentials accidentally printed by builds.
+// The first and second submatch (if any) are kept around the masked value.
+var tokenPatterns = []*regexp.Regexp{
+	// GitHub personal, OAuth, app and refresh tokens
+	regexp.MustCompile(`()\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`),
+	// GitLab personal access tokens
+	regexp.MustCompile(`()\bglpat-[A-Za-z0-9_\-]{20,}`),
+	// GoBuilder API tokens
+	regexp.MustCompile(`()\bgbt_[0-9a-f]{40}`),
+	// Slack tokens
+	regexp.MustCompile(`()\bxox[abprs]-[A-Za-z0-9\-]{10,}`),
+	// AWS access key IDs
+	regexp.MustCompile(`()\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	// Credentials in URLs
+	regexp.MustCompile(`([a-z][a-z0-9+.\-]*://[^/\s:@]+:)[^/\s@]+(@)`),
+	// Authorization headers
+	regexp.MustCompile(`(?i)((?:authorization|private-token):\s*(?:bearer |token |basic )?)[^\s'"]+`),
+	// Private keys
+	regexp.MustCompile(`(?s)()-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`),
+}
+
+// Mask replaces the known secret values and all values looking like
+// common credentials in the build log
+func Mask(buildLog string, secrets []string) string {
+	buildLog = maskValues(buildLog, secretValues(secrets))
+
+	for _, pattern := range tokenPatterns {
+		buildLog = pattern.ReplaceAllString(buildLog, "${1}"+MaskReplacement+"${2}")
+	}
+
+	return buildLog
+}
+
+// secretValues returns the values to mask for the secrets: the secrets
+// themselves, their single lines and the base64 encodings of both
+func secretValues(secrets []string) []string {
+	values := []string{}
+	for _, secret := range secrets {
+		// Multi-line secrets (like SSH keys) might be printed line by line
+		for _, value := range append([]string{secret}, strings.Split(secret, "\n")...) {
+			value = strings.TrimSpace(value)
+			if len(value) < 4 {
+				// Masking very short values would render the log unreadable
+				continue
+			}
+
+			values = append(values, value)
+			for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+				values = append(values, enc.EncodeToString([]byte(value)))
+			}
+		}
+	}
+	return values
+}
+
+// maskValues replaces all occurrences of the values in the build log.
+// Occurrences overlapping each other are replaced as a whole so no part
+// of any of the values is left in the log.
+func maskValues(buildLog string, values []string) string {
+	masked := make([]bool, len(buildLog))
+	found := false
+	for _, value := range values {
+		for offset := 0; ; {
+			i := strings.Index(buildLog[offset:], value)
+			if i < 0 {
+				break
+			}
+			for j := offset + i; j < offset+i+len(value); j++ {
+				masked[j] = true
+			}
+			offset += i + 1
+			found = true
+		}
+	}
+
+	if !found {
+		return buildLog
+	}
+
+	buf := bytes.Buffer{}
+	for i := 0; i < len(buildLog); i++ {
+		switch {
+		case !masked[i]:
+			buf.WriteByte(buildLog[i])
+		case i == 0 || !masked[i-1]:
+			buf.WriteString(MaskReplacement)
+		}
+	}
+	return buf.String()
+}
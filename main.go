@@ -16,9 +16,12 @@ import (
 	"launchpad.net/goamz/aws"
 	"launchpad.net/goamz/s3"
 
+	"github.com/Luzifer/gobuilder/assetstore"
 	"github.com/Luzifer/gobuilder/builddb"
 	"github.com/Luzifer/gobuilder/buildjob"
 	"github.com/Luzifer/gobuilder/config"
+	"github.com/Luzifer/gobuilder/downloads"
+	"github.com/Luzifer/gobuilder/secrets"
 	"github.com/flosch/pongo2"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/securecookie"
@@ -72,6 +75,12 @@ func init() {
 		os.Exit(1)
 	}
 
+	if err := secrets.SetWrapKey(cfg.KeyringKey); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Unable to set up keyring encryption")
+	}
+
 	sessionStoreAuthenticationKey := cfg.Session.AuthKey
 	if sessionStoreAuthenticationKey == "" {
 		sessionStoreAuthenticationKey = string(securecookie.GenerateRandomKey(32))
@@ -93,9 +102,12 @@ func init() {
 
 func main() {
 	connectS3()
+	go seedSearchIndex()
+	go wrapStoredKeyringKeys()
 
 	r := mux.NewRouter()
 	registerAPIv1(r)
+	registerAPIv2(r)
 
 	r.PathPrefix("/css/").Handler(http.FileServer(http.Dir("./frontend/")))
 	r.PathPrefix("/js/").Handler(http.FileServer(http.Dir("./frontend/")))
@@ -103,23 +115,39 @@ func main() {
 	r.Handle("/favicon.ico", http.FileServer(http.Dir("./frontend/")))
 	r.Handle("/robots.txt", http.FileServer(http.Dir("./frontend/")))
 	r.HandleFunc("/sitemap.xml", handleXMLSitemap).Methods("GET")
+	r.HandleFunc("/builds.atom", handleGlobalFeed).Methods("GET")
 
 	// Static handlers
 	r.HandleFunc("/", handleFrontPage).Methods("GET")
 	r.HandleFunc("/contact", handleImprint).Methods("GET")
 	r.HandleFunc("/help", handleHelpPage).Methods("GET")
+	r.HandleFunc("/search", handleSearchPage).Methods("GET")
 	r.Handle("/metrics", prometheus.Handler())
 
 	// GitHub auth
 	r.HandleFunc("/ghlogin", handleOauthGithubInit).Methods("GET")
 	r.HandleFunc("/ghlogout", handleOauthGithubLogout).Methods("GET")
 
+	// API tokens
+	r.HandleFunc("/tokens", handleAPITokens).Methods("GET")
+	r.HandleFunc("/tokens", handleAPITokenCreate).Methods("POST")
+	r.HandleFunc("/tokens/revoke", handleAPITokenRevoke).Methods("POST")
+
+	// Dashboard
+	r.HandleFunc("/dashboard/toggle", handleDashboardToggle).Methods("POST")
+
 	// Build starters / webhooks (deprecated bv /api/v1/webhook/*)
 	r.HandleFunc("/webhook/github", webhookGitHub).Methods("POST")
 	r.HandleFunc("/webhook/bitbucket", webhookBitBucket).Methods("POST")
 
 	// Build artifact displaying
 	r.HandleFunc("/get/{file:.+}", handlerDeliverFileFromS3).Methods("GET")
+	r.HandleFunc("/badge/{repo:.+}.svg", handleBadgeSVG).Methods("GET")
+	r.HandleFunc("/badge/{repo:.+}.json", handleBadgeJSON).Methods("GET")
+	r.HandleFunc("/{repo:.+}/builds.atom", handleBuildsFeed).Methods("GET")
+	r.HandleFunc("/{repo:.+}/releases.atom", handleReleasesFeed).Methods("GET")
+	r.HandleFunc("/{repo:.+}/log/{logid}.txt", handlerBuildLogText).Methods("GET")
+	r.HandleFunc("/{repo:.+}/log/{logid}.json", handlerBuildLogJSON).Methods("GET")
 	r.HandleFunc("/{repo:.+}/log/{logid}", handlerBuildLog).Methods("GET")
 	r.HandleFunc("/{repo:.+}", handlerRepositoryView).Methods("GET")
 
@@ -172,7 +200,16 @@ func handlerRepositoryView(res http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !checkRepoAccess(res, r, params["repo"]) {
+		return
+	}
+
 	buildStatus, err := redisClient.Get(fmt.Sprintf("project::%s::build-status", params["repo"]))
+	if builddb.IsPullRequestLabel(branch) {
+		if prStatus, prErr := redisClient.Get(fmt.Sprintf("project::%s::build-status::%s", params["repo"], branch)); prErr == nil && prStatus != nil {
+			buildStatus, err = prStatus, nil
+		}
+	}
 	if err != nil || buildStatus == nil {
 		log.WithFields(logrus.Fields{
 			"error": fmt.Sprintf("%v", err),
@@ -188,7 +225,7 @@ func handlerRepositoryView(res http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	readmeContent, err := s3Bucket.Get(fmt.Sprintf("%s/%s_README.md", params["repo"], branch))
+	readmeContent, err := assetstore.Get(redisClient, s3Bucket, fmt.Sprintf("%s/%s_README.md", params["repo"], branch))
 	if err != nil {
 		readmeContent = []byte("Project provided no README.md file.")
 	}
@@ -229,6 +266,28 @@ func handlerRepositoryView(res http.ResponseWriter, r *http.Request) {
 		hasBuilds = true
 	}
 
+	// The size chart covers more builds than the history table shows.
+	// Repositories built before the history was introduced are migrated
+	// by the build starter.
+	sizeHistory, err := builddb.History(redisClient, params["repo"], branch, 0, 29)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  params["repo"],
+		}).Error("Unable to load build history")
+	}
+	sizeChart, sizeSeries := renderSizeChart(sizeHistory)
+
+	history := sizeHistory
+	if len(history) > 10 {
+		history = history[:10]
+	}
+
+	var historicBuild *builddb.Build
+	if buildID := r.FormValue("build"); buildID != "" {
+		historicBuild, _ = builddb.GetBuild(redisClient, params["repo"], branch, buildID)
+	}
+
 	logs, err := redisClient.ZRevRange(fmt.Sprintf("project::%s::logs", params["repo"]), 0, 10, false)
 	if err != nil {
 		logs = []string{}
@@ -251,9 +310,21 @@ func handlerRepositoryView(res http.ResponseWriter, r *http.Request) {
 
 	abortReason, _ := redisClient.Get(fmt.Sprintf("project::%s::abort", params["repo"]))
 
+	downloadStats, err := downloads.Get(redisClient, params["repo"], 30)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  params["repo"],
+		}).Error("Unable to load download statistics")
+	}
+
 	template := pongo2.Must(pongo2.FromFile("frontend/repository.html"))
 	branches := []builddb.BranchSortEntry{}
 	for k, v := range buildDB {
+		if builddb.IsPullRequestLabel(k) && k != branch {
+			// Pull request builds are only listed when explicitly requested
+			continue
+		}
 		branches = append(branches, builddb.BranchSortEntry{Branch: k, BuildDate: v.BuildDate})
 	}
 	sort.Sort(sort.Reverse(builddb.BranchSortEntryByBuildDate(branches)))
@@ -263,6 +334,13 @@ func handlerRepositoryView(res http.ResponseWriter, r *http.Request) {
 	ctx["branches"] = branches
 	ctx["repo"] = params["repo"]
 	ctx["mybranch"] = buildDB[branch]
+	ctx["history"] = history
+	ctx["size_chart"] = sizeChart
+	ctx["size_series"] = sizeSeries
+	if historicBuild != nil {
+		ctx["mybranch"] = historicBuild.Branch()
+		ctx["historic"] = historicBuild
+	}
 	ctx["buildStatus"] = string(buildStatus)
 	ctx["readme"] = string(readmeContent)
 	ctx["hasbuilds"] = hasBuilds
@@ -270,6 +348,20 @@ func handlerRepositoryView(res http.ResponseWriter, r *http.Request) {
 	ctx["signature"] = string(signature)
 	ctx["logs"] = logMetas
 	ctx["abort"] = string(abortReason)
+	ctx["downloads"] = downloadStats
+	ctx["badge_url"] = badgeURL(params["repo"])
+
+	repoOwner := isRepoOwner(r, params["repo"])
+	ctx["private"] = isPrivateRepo(params["repo"])
+	ctx["repo_admin"] = getRepoAccessLevel(r, params["repo"]) == repoAccessAdmin
+	ctx["repo_owner"] = repoOwner
+	ctx["private_scope"] = hasPrivateRepoScope(r)
+	ctx["private_login_url"] = privateRepoLoginURL(params["repo"])
+
+	if repoOwner {
+		reencryptHints, _ := redisClient.Get(fmt.Sprintf("project::%s::reencrypt-hints", params["repo"]))
+		ctx["reencrypt_hints"], _ = strconv.Atoi(string(reencryptHints))
+	}
 
 	template.ExecuteWriter(ctx, res)
 }
@@ -16,11 +16,16 @@ var (
 		Name: "gobuilder_queuelength",
 		Help: "Current number of items in the build queue",
 	})
+	metricDownloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gobuilder_downloads_total",
+		Help: "Number of assets delivered by platform",
+	}, []string{"platform"})
 )
 
 func init() {
 	prometheus.MustRegister(metricActiveWorkers)
 	prometheus.MustRegister(metricQueueLength)
+	prometheus.MustRegister(metricDownloads)
 
 	go fetchMetrics()
 }
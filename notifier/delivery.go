@@ -0,0 +1,121 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/gobuilder/config"
+)
+
+// These variables control how often and with which delay failed
+// deliveries are retried. Only transient failures are retried.
+var (
+	RetryAttempts = 3
+	RetryBackoff  = 2 * time.Second
+)
+
+// Delivery records a single attempt to send a notification
+type Delivery struct {
+	Entry        int       `json:"entry"`
+	Type         string    `json:"type"`
+	Attempt      int       `json:"attempt"`
+	Success      bool      `json:"success"`
+	ResponseCode int       `json:"response_code,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// ToString creates a JSON representation to store in Redis
+func (d *Delivery) ToString() (string, error) {
+	b, err := json.Marshal(d)
+	return string(b), err
+}
+
+// DeliveryFromString reads a representation created by ToString
+func DeliveryFromString(s string) (*Delivery, error) {
+	tmp := &Delivery{}
+	if err := json.Unmarshal([]byte(s), tmp); err != nil {
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// HTTPError is returned by notifiers if the remote service responded
+// with a non successful status code
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (h *HTTPError) Error() string {
+	if h.Body == "" {
+		return fmt.Sprintf("Non successful notification (code = %d)", h.StatusCode)
+	}
+	return fmt.Sprintf("Non successful notification (code = %d) +++ %s", h.StatusCode, h.Body)
+}
+
+// isTransient checks whether retrying the notification might succeed
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case *HTTPError:
+		return e.StatusCode >= 500 || e.StatusCode == 429
+	case net.Error:
+		return true
+	default:
+		return false
+	}
+}
+
+// publicError removes the request URL and the target of the entry from
+// the error as the delivery log is visible to everyone able to view the
+// build log
+func publicError(err error, entry NotifyEntry) string {
+	if uErr, ok := err.(*url.Error); ok {
+		err = uErr.Err
+	}
+
+	msg := err.Error()
+	for _, secret := range []string{entry.Target, entry.Secret} {
+		if secret != "" {
+			msg = strings.Replace(msg, secret, "***", -1)
+		}
+	}
+	return msg
+}
+
+// deliver sends the notification using the notifier and retries transient
+// failures with an exponential backoff. Every attempt is recorded.
+func deliver(notifier Notifier, entryNo int, entry NotifyEntry, metadata NotifyMetaData, cfg *config.Config) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	backoff := RetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := notifier.Notify(entry, metadata, cfg)
+
+		d := Delivery{
+			Entry:   entryNo,
+			Type:    entry.Type,
+			Attempt: attempt,
+			Success: err == nil,
+			Time:    time.Now(),
+		}
+		if err != nil {
+			d.Error = publicError(err, entry)
+			if httpErr, ok := err.(*HTTPError); ok {
+				d.ResponseCode = httpErr.StatusCode
+			}
+		}
+		deliveries = append(deliveries, d)
+
+		if err == nil || !isTransient(err) || attempt >= RetryAttempts {
+			return deliveries, err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
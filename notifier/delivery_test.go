@@ -0,0 +1,213 @@
+package notifier
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/gobuilder/config"
+)
+
+func withRetryBackoff(t *testing.T, backoff time.Duration) {
+	attempts, former := RetryAttempts, RetryBackoff
+	RetryAttempts, RetryBackoff = 3, backoff
+	t.Cleanup(func() { RetryAttempts, RetryBackoff = attempts, former })
+}
+
+// statusServer responds with the given status codes in order and records
+// the time of every request
+func statusServer(codes ...int) (*httptest.Server, func() []time.Time) {
+	var (
+		mu       sync.Mutex
+		requests []time.Time
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		code := codes[len(codes)-1]
+		if len(requests) < len(codes) {
+			code = codes[len(requests)]
+		}
+		requests = append(requests, time.Now())
+		res.WriteHeader(code)
+	}))
+
+	return srv, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time{}, requests...)
+	}
+}
+
+func TestDeliveryRetriesTransientFailures(t *testing.T) {
+	withRetryBackoff(t, 20*time.Millisecond)
+
+	srv, requests := statusServer(http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	defer srv.Close()
+
+	n := NotifyConfiguration{{Type: "webhook", Target: srv.URL, Secret: "s3cr3t"}}
+	deliveries, err := n.Execute(testMetaData(), &config.Config{}, testKeyring(t))
+	if err != nil {
+		t.Fatalf("Delivery failed: %s", err)
+	}
+
+	if len(deliveries) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d: %+v", len(deliveries), deliveries)
+	}
+	for i, code := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, 0} {
+		d := deliveries[i]
+		if d.Attempt != i+1 || d.Entry != 1 || d.ResponseCode != code || d.Success != (code == 0) {
+			t.Errorf("Unexpected delivery #%d: %+v", i+1, d)
+		}
+	}
+
+	times := requests()
+	if len(times) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(times))
+	}
+	if d := times[1].Sub(times[0]); d < 20*time.Millisecond {
+		t.Errorf("First retry was sent after %s", d)
+	}
+	if d := times[2].Sub(times[1]); d < 40*time.Millisecond {
+		t.Errorf("Backoff was not increased, second retry was sent after %s", d)
+	}
+}
+
+func TestDeliveryStopsAfterRetryAttempts(t *testing.T) {
+	withRetryBackoff(t, time.Millisecond)
+
+	srv, requests := statusServer(http.StatusBadGateway)
+	defer srv.Close()
+
+	n := NotifyConfiguration{{Type: "webhook", Target: srv.URL, Secret: "s3cr3t"}}
+	deliveries, err := n.Execute(testMetaData(), &config.Config{}, testKeyring(t))
+	if err == nil {
+		t.Fatal("Failed delivery was not reported")
+	}
+
+	if len(deliveries) != RetryAttempts || len(requests()) != RetryAttempts {
+		t.Errorf("Expected %d attempts, got %d deliveries and %d requests", RetryAttempts, len(deliveries), len(requests()))
+	}
+}
+
+func TestDeliveryDoesNotRetryPermanentFailures(t *testing.T) {
+	withRetryBackoff(t, time.Millisecond)
+
+	srv, requests := statusServer(http.StatusNotFound)
+	defer srv.Close()
+
+	n := NotifyConfiguration{{Type: "webhook", Target: srv.URL, Secret: "s3cr3t"}}
+	deliveries, err := n.Execute(testMetaData(), &config.Config{}, testKeyring(t))
+	if err == nil {
+		t.Fatal("Failed delivery was not reported")
+	}
+
+	if len(deliveries) != 1 || len(requests()) != 1 || deliveries[0].ResponseCode != http.StatusNotFound {
+		t.Errorf("Permanent failure was retried: %+v", deliveries)
+	}
+}
+
+func TestDeliveryFailureDoesNotStopOtherEntries(t *testing.T) {
+	withRetryBackoff(t, time.Millisecond)
+
+	failing, _ := statusServer(http.StatusBadRequest)
+	defer failing.Close()
+	working, requests := statusServer(http.StatusOK)
+	defer working.Close()
+
+	n := NotifyConfiguration{
+		{Type: "webhook", Target: failing.URL, Secret: "s3cr3t"},
+		{Type: "webhook", Target: working.URL, Secret: "s3cr3t"},
+		{Type: "webhook", Target: working.URL, Secret: "s3cr3t", Filter: "error"},
+	}
+	deliveries, err := n.Execute(testMetaData(), &config.Config{}, testKeyring(t))
+	if err == nil || err.Error() != "1 of the notifications failed" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if len(deliveries) != 2 || deliveries[0].Entry != 1 || deliveries[1].Entry != 2 || !deliveries[1].Success {
+		t.Errorf("Unexpected deliveries: %+v", deliveries)
+	}
+	if len(requests()) != 1 {
+		t.Errorf("Filtered entry was sent")
+	}
+}
+
+func TestDeliveryLogRoundTrip(t *testing.T) {
+	d := &Delivery{
+		Entry:        2,
+		Type:         "webhook",
+		Attempt:      3,
+		ResponseCode: http.StatusBadGateway,
+		Error:        "Non successful notification (code = 502)",
+		Time:         time.Date(2015, 10, 18, 12, 0, 0, 0, time.UTC),
+	}
+
+	s, err := d.ToString()
+	if err != nil {
+		t.Fatalf("Unable to serialize delivery: %s", err)
+	}
+
+	read, err := DeliveryFromString(s)
+	if err != nil {
+		t.Fatalf("Unable to read delivery: %s", err)
+	}
+	if *read != *d {
+		t.Errorf("Delivery changed: %+v != %+v", read, d)
+	}
+
+	if _, err := DeliveryFromString("not json"); err == nil {
+		t.Error("Invalid delivery was accepted")
+	}
+}
+
+func TestPublicErrorRedactsTarget(t *testing.T) {
+	withRetryBackoff(t, time.Millisecond)
+
+	// The closed server refuses the connection causing an url.Error
+	// containing the full target URL
+	srv, _ := statusServer(http.StatusOK)
+	target := srv.URL + "/hook?token=t0k3n"
+	srv.Close()
+
+	keyring := testKeyring(t)
+	encTarget, _ := keyring.Encrypt(target)
+
+	n := NotifyConfiguration{{Type: "webhook", Target: encTarget, Secret: "s3cr3t"}}
+	deliveries, err := n.Execute(testMetaData(), &config.Config{}, keyring)
+	if err == nil {
+		t.Fatal("Delivery to closed server succeeded")
+	}
+
+	if len(deliveries) != RetryAttempts {
+		t.Errorf("Connection errors were not retried: %+v", deliveries)
+	}
+	for _, d := range deliveries {
+		if d.Error == "" || strings.Contains(d.Error, "t0k3n") || strings.Contains(d.Error, "/hook") {
+			t.Errorf("Delivery log exposes the target: %q", d.Error)
+		}
+	}
+}
+
+func TestPublicErrorRedactsSecrets(t *testing.T) {
+	entry := NotifyEntry{Target: "https://hooks.example.com/T0K3N", Secret: "s3cr3t"}
+	err := &HTTPError{StatusCode: http.StatusForbidden, Body: "invalid signature for s3cr3t at https://hooks.example.com/T0K3N"}
+
+	msg := publicError(err, entry)
+	if strings.Contains(msg, "s3cr3t") || strings.Contains(msg, "T0K3N") {
+		t.Errorf("Error exposes secrets: %q", msg)
+	}
+	if !strings.Contains(msg, "code = 403") {
+		t.Errorf("Error lost its details: %q", msg)
+	}
+
+	if msg := publicError(errors.New("plain failure"), NotifyEntry{}); msg != "plain failure" {
+		t.Errorf("Error without secrets was changed: %q", msg)
+	}
+}
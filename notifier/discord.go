@@ -0,0 +1,22 @@
+package notifier
+
+import "github.com/Luzifer/gobuilder/config"
+
+func init() {
+	Register("discord", discordNotifier{})
+}
+
+type discordNotifier struct{}
+
+// Notify posts a message to the Discord webhook URL in the target
+func (discordNotifier) Notify(n NotifyEntry, metadata NotifyMetaData, cfg *config.Config) error {
+	msg, err := n.message(metadata)
+	if err != nil {
+		return err
+	}
+
+	return sendJSON("POST", n.Target, map[string]string{
+		"content":  msg,
+		"username": "GoBuilder",
+	}, nil)
+}
@@ -0,0 +1,41 @@
+package notifier
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Luzifer/gobuilder/config"
+)
+
+func TestDiscordNotify(t *testing.T) {
+	srv := newCaptureServer(http.StatusNoContent)
+	defer srv.Close()
+
+	n := NotifyEntry{Type: "discord", Target: srv.URL + "/api/webhooks/1/T0K3N"}
+	if err := (discordNotifier{}).Notify(n, testMetaData(), &config.Config{}); err != nil {
+		t.Fatalf("Notification failed: %s", err)
+	}
+
+	req := srv.Request(t)
+	if req.Method != "POST" || req.Path != "/api/webhooks/1/T0K3N" {
+		t.Errorf("Unexpected request %s %s", req.Method, req.Path)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+
+	body := req.JSON(t)
+	if body["content"] != testDefaultMessage || body["username"] != "GoBuilder" {
+		t.Errorf("Unexpected payload %v", body)
+	}
+}
+
+func TestDiscordNotifyErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusBadGateway} {
+		srv := newCaptureServer(status)
+
+		err := (discordNotifier{}).Notify(NotifyEntry{Type: "discord", Target: srv.URL}, testMetaData(), &config.Config{})
+		expectHTTPError(t, err, status)
+		srv.Close()
+	}
+}
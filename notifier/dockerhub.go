@@ -1,13 +1,20 @@
 package notifier
 
 import (
-	"errors"
 	"net/http"
 	"net/url"
+
+	"github.com/Luzifer/gobuilder/config"
 )
 
-// NotifyDockerHub calls a DockerHub webhook to build a container after building the artifacts
-func (n *NotifyEntry) NotifyDockerHub(metadata NotifyMetaData) error {
+func init() {
+	Register("dockerhub", dockerHubNotifier{})
+}
+
+type dockerHubNotifier struct{}
+
+// Notify calls a DockerHub webhook to build a container after building the artifacts
+func (dockerHubNotifier) Notify(n NotifyEntry, metadata NotifyMetaData, cfg *config.Config) error {
 	if metadata.EventType != "success" {
 		return nil
 	}
@@ -22,5 +29,5 @@ func (n *NotifyEntry) NotifyDockerHub(metadata NotifyMetaData) error {
 		return nil
 	}
 
-	return errors.New(resp.Status)
+	return &HTTPError{StatusCode: resp.StatusCode}
 }
@@ -0,0 +1,60 @@
+package notifier
+
+import (
+	"errors"
+
+	"github.com/Luzifer/gobuilder/config"
+	"github.com/Luzifer/gobuilder/frontend"
+	"github.com/flosch/pongo2"
+)
+
+func init() {
+	Register("email", emailNotifier{})
+}
+
+type emailNotifier struct{}
+
+// Notify renders a predefined template for the current build and sends it
+// using the mail transport selected at startup
+func (emailNotifier) Notify(n NotifyEntry, metadata NotifyMetaData, cfg *config.Config) error {
+	if mailTransport == nil {
+		return errors.New("No mail transport configured")
+	}
+
+	subject, err := n.subject(metadata)
+	if err != nil {
+		return err
+	}
+
+	mailContent, err := n.mailBody(metadata)
+	if err != nil {
+		return err
+	}
+
+	return mailTransport.SendMail(n.Target, subject, mailContent)
+}
+
+// mailBody renders the message template of the entry or the predefined
+// HTML template if the entry has no own template
+func (n NotifyEntry) mailBody(metadata NotifyMetaData) (string, error) {
+	if n.Message != "" {
+		return n.message(metadata)
+	}
+
+	ctx := metadata.TemplateContext()
+	ctx["state"] = "successful"
+	if metadata.EventType == "error" {
+		ctx["state"] = "failed"
+	}
+	ctx["email"] = n.Target
+
+	tpl, err := frontend.Asset("mailgun_notifier.html")
+	if err != nil {
+		return "", err
+	}
+	template, err := pongo2.FromString(string(tpl))
+	if err != nil {
+		return "", err
+	}
+	return template.Execute(ctx)
+}
@@ -0,0 +1,35 @@
+package notifier
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// maildirTransport stores e-mails into a local Maildir instead of sending
+// them which is useful for testing notifications
+type maildirTransport struct {
+	Directory string
+	Sender    string
+}
+
+func (m maildirTransport) SendMail(to, subject, html string) error {
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(path.Join(m.Directory, dir), 0755); err != nil {
+			return err
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d.%s", time.Now().UnixNano(), os.Getpid(), hostname)
+
+	// Maildir requires to write into tmp and move the file afterwards
+	tmpFile := path.Join(m.Directory, "tmp", name)
+	if err := ioutil.WriteFile(tmpFile, buildMIMEMessage(m.Sender, to, subject, html), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, path.Join(m.Directory, "new", name))
+}
@@ -0,0 +1,68 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"time"
+
+	"github.com/Luzifer/gobuilder/config"
+)
+
+// MailTransport delivers the e-mails rendered by the email notifier
+type MailTransport interface {
+	SendMail(to, subject, html string) error
+}
+
+var mailTransport MailTransport
+
+// SetupMailTransport selects the transport configured in the Mail section
+// of the config to be used by the email notifier
+func SetupMailTransport(cfg *config.Config) error {
+	switch cfg.Mail.Transport {
+	case "mailgun":
+		mailTransport = mailgunTransport{
+			APIKey: cfg.MailGun.MailGunAPIKey,
+			Domain: cfg.MailGun.Domain,
+			Sender: cfg.Mail.Sender,
+		}
+	case "smtp":
+		if cfg.SMTP.Host == "" {
+			return fmt.Errorf("SMTP transport requires a host")
+		}
+		mailTransport = smtpTransport{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			StartTLS: cfg.SMTP.StartTLS,
+			Sender:   cfg.Mail.Sender,
+		}
+	case "maildir":
+		mailTransport = maildirTransport{
+			Directory: cfg.Mail.MaildirPath,
+			Sender:    cfg.Mail.Sender,
+		}
+	default:
+		return fmt.Errorf("Unknown mail transport %q", cfg.Mail.Transport)
+	}
+
+	return nil
+}
+
+// buildMIMEMessage creates a RFC 5322 message with a HTML body to be
+// delivered by transports not having an API for it
+func buildMIMEMessage(from, to, subject, html string) []byte {
+	buf := bytes.NewBuffer([]byte{})
+
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", to)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: text/html; charset=utf-8\r\n")
+	fmt.Fprintf(buf, "\r\n")
+	buf.WriteString(html)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,45 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/gobuilder/config"
+)
+
+func init() {
+	Register("matrix", matrixNotifier{})
+}
+
+type matrixNotifier struct{}
+
+// Notify sends a message into the Matrix room given as the target using
+// the account configured for GoBuilder. The account needs to be invited
+// into the room before.
+func (matrixNotifier) Notify(n NotifyEntry, metadata NotifyMetaData, cfg *config.Config) error {
+	if cfg.Matrix.AccessToken == "" {
+		return errors.New("No Matrix access token configured")
+	}
+
+	msg, err := n.message(metadata)
+	if err != nil {
+		return err
+	}
+
+	sendURL := fmt.Sprintf("%s/_matrix/client/r0/rooms/%s/send/m.room.message/gobuilder-%d",
+		strings.TrimRight(cfg.Matrix.HomeserverURL, "/"),
+		url.PathEscape(n.Target),
+		time.Now().UnixNano(),
+	)
+
+	return sendJSON("PUT", sendURL, map[string]string{
+		"msgtype": "m.text",
+		"body":    msg,
+	}, http.Header{
+		"Authorization": []string{"Bearer " + cfg.Matrix.AccessToken},
+	})
+}
@@ -0,0 +1,58 @@
+package notifier
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Luzifer/gobuilder/config"
+)
+
+func testMatrixConfig(homeserver string) *config.Config {
+	cfg := &config.Config{}
+	cfg.Matrix.HomeserverURL = homeserver + "/"
+	cfg.Matrix.AccessToken = "m4tr1x"
+	return cfg
+}
+
+func TestMatrixNotify(t *testing.T) {
+	srv := newCaptureServer(http.StatusOK)
+	defer srv.Close()
+
+	n := NotifyEntry{Type: "matrix", Target: "!abc:example.com"}
+	if err := (matrixNotifier{}).Notify(n, testMetaData(), testMatrixConfig(srv.URL)); err != nil {
+		t.Fatalf("Notification failed: %s", err)
+	}
+
+	req := srv.Request(t)
+	if req.Method != "PUT" {
+		t.Errorf("Unexpected method %s", req.Method)
+	}
+	if prefix := "/_matrix/client/r0/rooms/%21abc:example.com/send/m.room.message/gobuilder-"; !strings.HasPrefix(req.Path, prefix) {
+		t.Errorf("Unexpected path %q", req.Path)
+	}
+	if auth := req.Header.Get("Authorization"); auth != "Bearer m4tr1x" {
+		t.Errorf("Unexpected authorization %q", auth)
+	}
+
+	body := req.JSON(t)
+	if body["msgtype"] != "m.text" || body["body"] != testDefaultMessage {
+		t.Errorf("Unexpected payload %v", body)
+	}
+}
+
+func TestMatrixNotifyErrors(t *testing.T) {
+	n := NotifyEntry{Type: "matrix", Target: "!abc:example.com"}
+
+	if err := (matrixNotifier{}).Notify(n, testMetaData(), &config.Config{}); err == nil {
+		t.Error("Notification without access token succeeded")
+	}
+
+	for _, status := range []int{http.StatusForbidden, http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		srv := newCaptureServer(status)
+
+		err := (matrixNotifier{}).Notify(n, testMetaData(), testMatrixConfig(srv.URL))
+		expectHTTPError(t, err, status)
+		srv.Close()
+	}
+}
@@ -1,61 +1,293 @@
 package notifier
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
-	"github.com/Luzifer/go-openssl"
+	"github.com/Luzifer/gobuilder/builddb"
 	"github.com/Luzifer/gobuilder/config"
+	"github.com/Luzifer/gobuilder/secrets"
+	"github.com/flosch/pongo2"
 )
 
+// Notifier is implemented by every notification method and sends the
+// notification for a single NotifyEntry
+type Notifier interface {
+	Notify(entry NotifyEntry, metadata NotifyMetaData, cfg *config.Config) error
+}
+
+// Validator can be implemented by a Notifier requiring more than a target
+// to be configured in the NotifyEntry
+type Validator interface {
+	Validate(entry NotifyEntry) error
+}
+
+var notifiers = map[string]Notifier{}
+
+// Register makes a Notifier available for the given notification type
+func Register(notifyType string, n Notifier) {
+	notifiers[notifyType] = n
+}
+
+// IsRegistered checks whether a Notifier for the notification type exists
+func IsRegistered(notifyType string) bool {
+	_, ok := notifiers[notifyType]
+	return ok
+}
+
 // NotifyEntry represents a configuration for a single notification method
 type NotifyEntry struct {
-	// Type can be one of these: "dockerhub"
+	// Type can be one of the registered notifiers (e.g. "dockerhub", "slack")
 	Type string `yaml:"type"`
 	// Target represents a target expected by the notification Type
 	Target string `yaml:"target"`
 	// Filter determines whether to send a notification. Expected is a comma seperated list of EventTypes (e.g. "success,error")
 	Filter string `yaml:"filter,omitempty"`
+	// Secret is used by notification types signing their payload (e.g. "webhook")
+	Secret string `yaml:"secret,omitempty"`
+	// Subject is a pongo2 template for the subject / title of the notification
+	Subject string `yaml:"subject,omitempty"`
+	// Message is a pongo2 template for the body of the notification
+	Message string `yaml:"message,omitempty"`
 }
 
 // NotifyMetaData contains information from the build process about the
 // build for the notification process
 type NotifyMetaData struct {
-	EventType  string
-	Repository string
+	EventType   string
+	Repository  string
+	Commit      string
+	Labels      []string
+	Duration    time.Duration
+	AbortReason string
+	BuildLogID  string
+	Assets      []builddb.Asset
+	BaseURL     string
+	// Warnings describe problems not failing the build (e.g. an exceeded
+	// size budget)
+	Warnings []string
+}
+
+// Verb describes the EventType for use in messages
+func (m NotifyMetaData) Verb() string {
+	if m.EventType == "error" {
+		return "failed"
+	}
+	return "succeeded"
+}
+
+// ShortCommit returns the abbreviated commit hash
+func (m NotifyMetaData) ShortCommit() string {
+	if len(m.Commit) > 7 {
+		return m.Commit[0:7]
+	}
+	return m.Commit
+}
+
+// RepoURL returns the link to the repository page on GoBuilder
+func (m NotifyMetaData) RepoURL() string {
+	return fmt.Sprintf("%s/%s", m.baseURL(), m.Repository)
+}
+
+// LogURL returns the link to the build log or the repository page if
+// the build log is not available
+func (m NotifyMetaData) LogURL() string {
+	if m.BuildLogID == "" {
+		return m.RepoURL()
+	}
+	return fmt.Sprintf("%s/%s/log/%s", m.baseURL(), m.Repository, m.BuildLogID)
+}
+
+// AssetURL returns the download link for a file created by the build
+func (m NotifyMetaData) AssetURL(fileName string) string {
+	return fmt.Sprintf("%s/get/%s/%s", m.baseURL(), m.Repository, fileName)
+}
+
+func (m NotifyMetaData) baseURL() string {
+	if m.BaseURL == "" {
+		return "https://gobuilder.me"
+	}
+	return strings.TrimRight(m.BaseURL, "/")
+}
+
+// TemplateContext exposes the metadata to the user templates of the
+// notification entries
+func (m NotifyMetaData) TemplateContext() pongo2.Context {
+	return pongo2.Context{
+		"event":        m.EventType,
+		"state":        m.Verb(),
+		"repo":         m.Repository,
+		"repo_link":    m.RepoURL(),
+		"commit":       m.Commit,
+		"short_commit": m.ShortCommit(),
+		"labels":       m.Labels,
+		"duration":     int(m.Duration.Seconds()),
+		"abort_reason": m.AbortReason,
+		"warnings":     m.Warnings,
+		"log_link":     m.LogURL(),
+		"assets":       m.Assets,
+	}
 }
 
 // NotifyConfiguration represents a list of notification methods
 type NotifyConfiguration []NotifyEntry
 
-// Execute iterates over all configured notification methods and calls
-// the respective methods
-func (n *NotifyConfiguration) Execute(metadata NotifyMetaData, cfg *config.Config, encryptionKey string) error {
-	for _, method := range *n {
-		if len(strings.TrimSpace(method.Filter)) == 0 || strings.Contains(method.Filter, metadata.EventType) {
-			var err error
-
-			if strings.HasPrefix(method.Target, "U2FsdGVkX1") {
-				// Data was encrypted before
-				o := openssl.New()
-				dec, err := o.DecryptString(encryptionKey, method.Target)
-				if err != nil {
-					return err
-				}
-				method.Target = string(dec)
-			}
-
-			switch method.Type {
-			case "dockerhub":
-				err = method.NotifyDockerHub(metadata)
-			case "pushover":
-				err = method.NotifyPushover(metadata, cfg)
-			case "email":
-				err = method.NotifyEMail(metadata, cfg)
+// Validate ensures all configured notification methods are known and
+// have a target configured
+func (n NotifyConfiguration) Validate() error {
+	for i, method := range n {
+		if !IsRegistered(method.Type) {
+			return fmt.Errorf("Notification #%d has unknown type %q", i+1, method.Type)
+		}
+		if strings.TrimSpace(method.Target) == "" {
+			return fmt.Errorf("Notification #%d (%s) has no target", i+1, method.Type)
+		}
+		for _, tpl := range []string{method.Subject, method.Message} {
+			if _, err := compileUserTemplate(tpl); err != nil {
+				return fmt.Errorf("Notification #%d (%s) has an invalid template: %s", i+1, method.Type, err)
 			}
-			if err != nil {
-				return err
+		}
+		if v, ok := notifiers[method.Type].(Validator); ok {
+			if err := v.Validate(method); err != nil {
+				return fmt.Errorf("Notification #%d (%s) is invalid: %s", i+1, method.Type, err)
 			}
 		}
 	}
 	return nil
 }
+
+// Execute iterates over all configured notification methods and calls
+// the respective Notifier. A failing notification does not prevent the
+// remaining ones from being sent. All delivery attempts are returned and
+// an error is returned if at least one notification finally failed.
+func (n *NotifyConfiguration) Execute(metadata NotifyMetaData, cfg *config.Config, keyring *secrets.Keyring) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	failed := 0
+
+	for i, method := range *n {
+		if len(strings.TrimSpace(method.Filter)) > 0 && !strings.Contains(method.Filter, metadata.EventType) {
+			continue
+		}
+
+		d, err := executeEntry(i+1, method, metadata, cfg, keyring)
+		deliveries = append(deliveries, d...)
+		if err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return deliveries, fmt.Errorf("%d of the notifications failed", failed)
+	}
+	return deliveries, nil
+}
+
+func executeEntry(entryNo int, method NotifyEntry, metadata NotifyMetaData, cfg *config.Config, keyring *secrets.Keyring) ([]Delivery, error) {
+	failure := func(err error) ([]Delivery, error) {
+		return []Delivery{{
+			Entry:   entryNo,
+			Type:    method.Type,
+			Attempt: 1,
+			Error:   err.Error(),
+			Time:    time.Now(),
+		}}, err
+	}
+
+	var err error
+	if method.Target, err = keyring.Decrypt(method.Target); err != nil {
+		return failure(fmt.Errorf("Unable to decrypt target: %s", err))
+	}
+	if method.Secret, err = keyring.Decrypt(method.Secret); err != nil {
+		return failure(fmt.Errorf("Unable to decrypt secret: %s", err))
+	}
+
+	notifier, ok := notifiers[method.Type]
+	if !ok {
+		return failure(fmt.Errorf("Unknown notification type %q", method.Type))
+	}
+
+	return deliver(notifier, entryNo, method, metadata, cfg)
+}
+
+// render executes the user template or returns the fallback if no
+// template is configured
+func render(tpl, fallback string, metadata NotifyMetaData) (string, error) {
+	if tpl == "" {
+		return fallback, nil
+	}
+
+	template, err := compileUserTemplate(tpl)
+	if err != nil {
+		return "", err
+	}
+	return template.Execute(metadata.TemplateContext())
+}
+
+// subject renders the subject template of the entry or the default subject
+func (n NotifyEntry) subject(metadata NotifyMetaData) (string, error) {
+	return render(n.Subject, fmt.Sprintf("GoBuilder build of %s %s", metadata.Repository, metadata.Verb()), metadata)
+}
+
+// message renders the message template of the entry or the default short
+// text message used by chat based notifiers
+func (n NotifyEntry) message(metadata NotifyMetaData) (string, error) {
+	msg := fmt.Sprintf("The build for repo %s", metadata.Repository)
+	if metadata.Commit != "" {
+		msg += fmt.Sprintf(" at %s", metadata.ShortCommit())
+	}
+	if len(metadata.Labels) > 0 {
+		msg += fmt.Sprintf(" (%s)", strings.Join(metadata.Labels, ", "))
+	}
+	msg += " " + metadata.Verb()
+	if metadata.Duration > 0 {
+		msg += fmt.Sprintf(" after %ds", int(metadata.Duration.Seconds()))
+	}
+	if metadata.AbortReason != "" {
+		msg += fmt.Sprintf(": %s", metadata.AbortReason)
+	}
+	if len(metadata.Warnings) > 0 {
+		msg += fmt.Sprintf(" with warnings: %s", strings.Join(metadata.Warnings, "; "))
+	}
+	msg += fmt.Sprintf(" - %s", metadata.LogURL())
+
+	return render(n.Message, msg, metadata)
+}
+
+// sendJSON transmits the payload to the URL and expects a 2xx response
+func sendJSON(method, url string, payload interface{}, header http.Header) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return sendBody(method, url, body, header)
+}
+
+// sendBody transmits the JSON encoded body to the URL and expects a 2xx
+// response
+func sendBody(method, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &HTTPError{StatusCode: resp.StatusCode}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package notifier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/gobuilder/builddb"
+)
+
+func testMetaData() NotifyMetaData {
+	return NotifyMetaData{
+		EventType:  "success",
+		Repository: "github.com/Luzifer/gobuilder",
+		Commit:     "3e1a7b9c2d4f6a8b0c1d3e5f7a9b1c3d5e7f9a1b",
+		Labels:     []string{"master", "v1.2.0"},
+		Duration:   94 * time.Second,
+		BuildLogID: "6f1e5b0c9d2a4b7e",
+		Assets: []builddb.Asset{
+			{FileName: "gobuilder_master_linux-amd64.zip", Size: 3542182, SHA256: "a2c5"},
+		},
+		BaseURL: "https://gobuilder.example.com/",
+	}
+}
+
+const testDefaultMessage = "The build for repo github.com/Luzifer/gobuilder at 3e1a7b9 (master, v1.2.0) succeeded after 94s - https://gobuilder.example.com/github.com/Luzifer/gobuilder/log/6f1e5b0c9d2a4b7e"
+
+// capturedRequest is a request received by the captureServer
+type capturedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// JSON decodes the body of the request into a string map
+func (c capturedRequest) JSON(t *testing.T) map[string]string {
+	v := map[string]string{}
+	if err := json.Unmarshal(c.Body, &v); err != nil {
+		t.Fatalf("Request body is no JSON object: %s (%q)", err, c.Body)
+	}
+	return v
+}
+
+// captureServer records all requests and responds with the status code
+type captureServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []capturedRequest
+}
+
+func newCaptureServer(status int) *captureServer {
+	c := &captureServer{status: status}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.requests = append(c.requests, capturedRequest{
+			Method: r.Method,
+			Path:   r.URL.EscapedPath(),
+			Header: r.Header,
+			Body:   body,
+		})
+		res.WriteHeader(c.status)
+	}))
+	return c
+}
+
+// Request returns the only request received by the server
+func (c *captureServer) Request(t *testing.T) capturedRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(c.requests))
+	}
+	return c.requests[0]
+}
+
+// expectHTTPError checks the notifier reported the status code of the
+// server as an HTTPError
+func expectHTTPError(t *testing.T, err error, status int) {
+	httpErr, ok := err.(*HTTPError)
+	if !ok {
+		t.Fatalf("Expected HTTPError, got %#v", err)
+	}
+	if httpErr.StatusCode != status {
+		t.Errorf("Expected status %d, got %d", status, httpErr.StatusCode)
+	}
+	if want := status >= 500 || status == http.StatusTooManyRequests; isTransient(err) != want {
+		t.Errorf("Status %d should be transient = %t", status, want)
+	}
+}
+
+func TestNotifyConfigurationValidate(t *testing.T) {
+	for _, n := range []NotifyConfiguration{
+		{{Type: "carrier-pigeon", Target: "somewhere"}},
+		{{Type: "slack", Target: " "}},
+		{{Type: "slack", Target: "https://hooks.slack.com/T0K3N", Message: "{{ repo "}},
+	} {
+		if err := n.Validate(); err == nil {
+			t.Errorf("Invalid configuration %+v was accepted", n)
+		}
+	}
+
+	n := NotifyConfiguration{
+		{Type: "slack", Target: "https://hooks.slack.com/T0K3N"},
+		{Type: "discord", Target: "https://discord.com/api/webhooks/1/T0K3N"},
+		{Type: "matrix", Target: "!abc:example.com"},
+		{Type: "telegram", Target: "12345"},
+	}
+	if err := n.Validate(); err != nil {
+		t.Errorf("Valid configuration was rejected: %s", err)
+	}
+}
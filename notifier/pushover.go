@@ -1,23 +1,32 @@
 package notifier
 
 import (
-	"fmt"
-
 	"github.com/Luzifer/gobuilder/config"
 	"github.com/thorduri/pushover"
 )
 
-// NotifyPushover uses the Pushover API to send notifications about the current build
-func (n *NotifyEntry) NotifyPushover(metadata NotifyMetaData, cfg *config.Config) error {
-	verb := "succeeded"
-	if metadata.EventType == "error" {
-		verb = "failed"
+func init() {
+	Register("pushover", pushoverNotifier{})
+}
+
+type pushoverNotifier struct{}
+
+// Notify uses the Pushover API to send notifications about the current build
+func (pushoverNotifier) Notify(n NotifyEntry, metadata NotifyMetaData, cfg *config.Config) error {
+	title, err := n.subject(metadata)
+	if err != nil {
+		return err
+	}
+
+	msg, err := n.message(metadata)
+	if err != nil {
+		return err
 	}
 
 	message := &pushover.Message{
-		Message:  fmt.Sprintf("The build for repo %s %s", metadata.Repository, verb),
-		Title:    fmt.Sprintf("GoBuilder.me %s", verb),
-		Url:      fmt.Sprintf("https://gobuilder.me/%s", metadata.Repository),
+		Message:  msg,
+		Title:    title,
+		Url:      metadata.LogURL(),
 		UrlTitle: "Go to your build...",
 		Priority: pushover.Normal,
 	}
@@ -0,0 +1,21 @@
+package notifier
+
+import "github.com/Luzifer/gobuilder/config"
+
+func init() {
+	Register("slack", slackNotifier{})
+}
+
+type slackNotifier struct{}
+
+// Notify posts a message to the Slack incoming webhook URL in the target
+func (slackNotifier) Notify(n NotifyEntry, metadata NotifyMetaData, cfg *config.Config) error {
+	msg, err := n.message(metadata)
+	if err != nil {
+		return err
+	}
+
+	return sendJSON("POST", n.Target, map[string]string{
+		"text": msg,
+	}, nil)
+}
@@ -0,0 +1,53 @@
+package notifier
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Luzifer/gobuilder/config"
+)
+
+func TestSlackNotify(t *testing.T) {
+	srv := newCaptureServer(http.StatusOK)
+	defer srv.Close()
+
+	n := NotifyEntry{Type: "slack", Target: srv.URL + "/services/T0K3N"}
+	if err := (slackNotifier{}).Notify(n, testMetaData(), &config.Config{}); err != nil {
+		t.Fatalf("Notification failed: %s", err)
+	}
+
+	req := srv.Request(t)
+	if req.Method != "POST" || req.Path != "/services/T0K3N" {
+		t.Errorf("Unexpected request %s %s", req.Method, req.Path)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	if body := req.JSON(t); len(body) != 1 || body["text"] != testDefaultMessage {
+		t.Errorf("Unexpected payload %v", body)
+	}
+}
+
+func TestSlackNotifyTemplate(t *testing.T) {
+	srv := newCaptureServer(http.StatusOK)
+	defer srv.Close()
+
+	n := NotifyEntry{Type: "slack", Target: srv.URL, Message: "{{ repo }} {{ state }}"}
+	if err := (slackNotifier{}).Notify(n, testMetaData(), &config.Config{}); err != nil {
+		t.Fatalf("Notification failed: %s", err)
+	}
+
+	if body := srv.Request(t).JSON(t); body["text"] != "github.com/Luzifer/gobuilder succeeded" {
+		t.Errorf("Template was not used: %v", body)
+	}
+}
+
+func TestSlackNotifyErrors(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError} {
+		srv := newCaptureServer(status)
+
+		err := (slackNotifier{}).Notify(NotifyEntry{Type: "slack", Target: srv.URL}, testMetaData(), &config.Config{})
+		expectHTTPError(t, err, status)
+		srv.Close()
+	}
+}
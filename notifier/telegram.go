@@ -0,0 +1,39 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Luzifer/gobuilder/config"
+)
+
+func init() {
+	Register("telegram", telegramNotifier{})
+}
+
+type telegramNotifier struct{}
+
+// Notify sends a message to the Telegram chat ID given as the target
+// using the bot configured for GoBuilder. The chat needs to be started
+// with the bot before.
+func (telegramNotifier) Notify(n NotifyEntry, metadata NotifyMetaData, cfg *config.Config) error {
+	if cfg.Telegram.BotToken == "" {
+		return errors.New("No Telegram bot token configured")
+	}
+
+	msg, err := n.message(metadata)
+	if err != nil {
+		return err
+	}
+
+	sendURL := fmt.Sprintf("%s/bot%s/sendMessage",
+		strings.TrimRight(cfg.Telegram.APIURL, "/"),
+		cfg.Telegram.BotToken,
+	)
+
+	return sendJSON("POST", sendURL, map[string]string{
+		"chat_id": n.Target,
+		"text":    msg,
+	}, nil)
+}
@@ -0,0 +1,54 @@
+package notifier
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Luzifer/gobuilder/config"
+)
+
+func testTelegramConfig(apiURL string) *config.Config {
+	cfg := &config.Config{}
+	cfg.Telegram.APIURL = apiURL
+	cfg.Telegram.BotToken = "123:b0t"
+	return cfg
+}
+
+func TestTelegramNotify(t *testing.T) {
+	srv := newCaptureServer(http.StatusOK)
+	defer srv.Close()
+
+	n := NotifyEntry{Type: "telegram", Target: "12345"}
+	if err := (telegramNotifier{}).Notify(n, testMetaData(), testTelegramConfig(srv.URL)); err != nil {
+		t.Fatalf("Notification failed: %s", err)
+	}
+
+	req := srv.Request(t)
+	if req.Method != "POST" || req.Path != "/bot123:b0t/sendMessage" {
+		t.Errorf("Unexpected request %s %s", req.Method, req.Path)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+
+	body := req.JSON(t)
+	if body["chat_id"] != "12345" || body["text"] != testDefaultMessage {
+		t.Errorf("Unexpected payload %v", body)
+	}
+}
+
+func TestTelegramNotifyErrors(t *testing.T) {
+	n := NotifyEntry{Type: "telegram", Target: "12345"}
+
+	if err := (telegramNotifier{}).Notify(n, testMetaData(), &config.Config{}); err == nil {
+		t.Error("Notification without bot token succeeded")
+	}
+
+	for _, status := range []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusInternalServerError} {
+		srv := newCaptureServer(status)
+
+		err := (telegramNotifier{}).Notify(n, testMetaData(), testTelegramConfig(srv.URL))
+		expectHTTPError(t, err, status)
+		srv.Close()
+	}
+}
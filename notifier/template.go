@@ -0,0 +1,35 @@
+package notifier
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/flosch/pongo2"
+)
+
+// userTemplates compiles the templates configured in the .gobuilder.yml
+// of the repositories. They must not be able to read any file from the
+// host sending the notifications.
+var userTemplates = pongo2.NewSet("notifications", denyLoader{})
+
+func init() {
+	for _, tag := range []string{"ssi", "include", "import", "extends"} {
+		if err := userTemplates.BanTag(tag); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// denyLoader refuses to load any template file
+type denyLoader struct{}
+
+func (denyLoader) Abs(base, name string) string { return name }
+
+func (denyLoader) Get(path string) (io.Reader, error) {
+	return nil, fmt.Errorf("Loading %q is not allowed in notification templates", path)
+}
+
+// compileUserTemplate compiles a template configured by a repository
+func compileUserTemplate(tpl string) (*pongo2.Template, error) {
+	return userTemplates.FromString(tpl)
+}
@@ -0,0 +1,44 @@
+package notifier
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUserTemplatesCannotLoadFiles(t *testing.T) {
+	for _, tpl := range []string{
+		`{% ssi "/proc/self/environ" %}`,
+		`{% include "/etc/passwd" %}`,
+		`{% import "/etc/passwd" macro %}`,
+		`{% extends "/etc/passwd" %}`,
+	} {
+		n := NotifyConfiguration{{Type: "webhook", Target: "https://example.com/hook", Secret: "s3cr3t", Message: tpl}}
+		if err := n.Validate(); err == nil {
+			t.Errorf("Template %q was accepted", tpl)
+		}
+
+		if out, err := render(tpl, "", testMetaData()); err == nil {
+			t.Errorf("Template %q was rendered: %q", tpl, out)
+		}
+	}
+}
+
+func TestUserTemplatesRender(t *testing.T) {
+	n := NotifyEntry{Subject: "{{ repo }}@{{ short_commit }}: build {{ state }}"}
+
+	subject, err := n.subject(testMetaData())
+	if err != nil {
+		t.Fatalf("Unable to render subject: %s", err)
+	}
+	if subject != "github.com/Luzifer/gobuilder@3e1a7b9: build succeeded" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+
+	msg, err := NotifyEntry{}.message(testMetaData())
+	if err != nil {
+		t.Fatalf("Unable to render message: %s", err)
+	}
+	if !strings.HasPrefix(msg, "The build for repo github.com/Luzifer/gobuilder at 3e1a7b9 (master, v1.2.0) succeeded after 94s") {
+		t.Errorf("Unexpected default message %q", msg)
+	}
+}
@@ -0,0 +1,114 @@
+package notifier
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/gobuilder/config"
+)
+
+// WebhookPayloadVersion is increased on every incompatible change of the
+// JSON document sent by the webhook notifier
+const WebhookPayloadVersion = 1
+
+func init() {
+	Register("webhook", webhookNotifier{})
+}
+
+type webhookNotifier struct{}
+
+// WebhookPayload is the JSON document POSTed to the target of a webhook
+// notification
+type WebhookPayload struct {
+	Version     int            `json:"version"`
+	Event       string         `json:"event"`
+	Repository  string         `json:"repository"`
+	Commit      string         `json:"commit"`
+	Labels      []string       `json:"labels"`
+	Status      string         `json:"status"`
+	Duration    int64          `json:"duration"`
+	AbortReason string         `json:"abort_reason,omitempty"`
+	Warnings    []string       `json:"warnings,omitempty"`
+	Assets      []WebhookAsset `json:"assets"`
+	LogURL      string         `json:"log_url,omitempty"`
+	Timestamp   time.Time      `json:"timestamp"`
+}
+
+// WebhookAsset describes a single file created by the build
+type WebhookAsset struct {
+	FileName   string `json:"file_name"`
+	Size       int64  `json:"size"`
+	BinarySize int64  `json:"binary_size,omitempty"`
+	SHA256     string `json:"sha256"`
+	URL        string `json:"url"`
+}
+
+// Validate ensures a secret to sign the payload is configured
+func (webhookNotifier) Validate(n NotifyEntry) error {
+	if strings.TrimSpace(n.Secret) == "" {
+		return errors.New("A secret to sign the payload is required")
+	}
+	return nil
+}
+
+// Notify POSTs a JSON document describing the build to the target URL.
+// The body is signed using HMAC-SHA256 with the secret of the entry and
+// the signature is sent in the X-GoBuilder-Signature header.
+func (webhookNotifier) Notify(n NotifyEntry, metadata NotifyMetaData, cfg *config.Config) error {
+	status := "success"
+	if metadata.EventType == "error" {
+		status = "failed"
+	}
+
+	payload := WebhookPayload{
+		Version:     WebhookPayloadVersion,
+		Event:       metadata.EventType,
+		Repository:  metadata.Repository,
+		Commit:      metadata.Commit,
+		Labels:      metadata.Labels,
+		Status:      status,
+		Duration:    int64(metadata.Duration.Seconds()),
+		AbortReason: metadata.AbortReason,
+		Warnings:    metadata.Warnings,
+		Assets:      []WebhookAsset{},
+		Timestamp:   time.Now().UTC(),
+	}
+
+	if metadata.BuildLogID != "" {
+		payload.LogURL = metadata.LogURL()
+	}
+
+	for _, asset := range metadata.Assets {
+		payload.Assets = append(payload.Assets, WebhookAsset{
+			FileName:   asset.FileName,
+			Size:       asset.Size,
+			BinarySize: asset.BinarySize,
+			SHA256:     asset.SHA256,
+			URL:        metadata.AssetURL(asset.FileName),
+		})
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return sendBody("POST", n.Target, body, http.Header{
+		"X-GoBuilder-Event":     []string{metadata.EventType},
+		"X-GoBuilder-Signature": []string{"sha256=" + SignPayload(body, n.Secret)},
+	})
+}
+
+// SignPayload creates the hex encoded HMAC-SHA256 signature of the body
+// using the secret. Receivers can use it to verify the signature header.
+func SignPayload(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
@@ -0,0 +1,87 @@
+package notifier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Luzifer/gobuilder/config"
+	"github.com/Luzifer/gobuilder/secrets"
+)
+
+func testKeyring(t *testing.T) *secrets.Keyring {
+	k := &secrets.Keyring{}
+	if err := k.Rotate(); err != nil {
+		t.Fatalf("Unable to create key: %s", err)
+	}
+	return k
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	var (
+		body   []byte
+		header http.Header
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected POST request, got %s", r.Method)
+		}
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+		res.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	keyring := testKeyring(t)
+	target, _ := keyring.Encrypt(srv.URL + "/hook")
+	secret, _ := keyring.Encrypt("s3cr3t")
+
+	n := NotifyConfiguration{{Type: "webhook", Target: target, Secret: secret}}
+	deliveries, err := n.Execute(testMetaData(), &config.Config{}, keyring)
+	if err != nil {
+		t.Fatalf("Delivery failed: %s", err)
+	}
+
+	if len(deliveries) != 1 || !deliveries[0].Success || deliveries[0].Attempt != 1 || deliveries[0].Type != "webhook" {
+		t.Errorf("Unexpected deliveries: %+v", deliveries)
+	}
+
+	if ct := header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	if ev := header.Get("X-GoBuilder-Event"); ev != "success" {
+		t.Errorf("Unexpected event header %q", ev)
+	}
+	if sig := header.Get("X-GoBuilder-Signature"); sig != "sha256="+SignPayload(body, "s3cr3t") {
+		t.Errorf("Signature %q does not match the body", sig)
+	}
+
+	payload := WebhookPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("Unable to parse payload: %s", err)
+	}
+
+	if payload.Version != WebhookPayloadVersion || payload.Status != "success" || payload.Duration != 94 {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+	if payload.LogURL != "https://gobuilder.example.com/github.com/Luzifer/gobuilder/log/6f1e5b0c9d2a4b7e" {
+		t.Errorf("Unexpected log URL %q", payload.LogURL)
+	}
+	if len(payload.Assets) != 1 || payload.Assets[0].URL != "https://gobuilder.example.com/get/github.com/Luzifer/gobuilder/gobuilder_master_linux-amd64.zip" {
+		t.Errorf("Unexpected assets: %+v", payload.Assets)
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	n := NotifyConfiguration{{Type: "webhook", Target: "https://example.com/hook"}}
+	if err := n.Validate(); err == nil {
+		t.Error("Webhook without secret was accepted")
+	}
+
+	n[0].Secret = "s3cr3t"
+	if err := n.Validate(); err != nil {
+		t.Errorf("Valid webhook was rejected: %s", err)
+	}
+}
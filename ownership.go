@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Luzifer/gobuilder/credentials"
+	"github.com/Sirupsen/logrus"
+)
+
+func userKey(login string) string {
+	return fmt.Sprintf("user::%s", login)
+}
+
+func repoOwnersKey(repo string) string {
+	return fmt.Sprintf("project::%s::owners", credentials.SourceRepository(repo))
+}
+
+// registerUser creates the user account on first login and refreshes
+// the time of the last login afterwards
+func registerUser(login string) error {
+	if _, err := redisClient.HSetnx(userKey(login), "created", strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
+		return err
+	}
+	_, err := redisClient.HSet(userKey(login), "last-login", strconv.FormatInt(time.Now().Unix(), 10))
+	return err
+}
+
+// getOwnedRepos lists the source repositories claimed by the user
+func getOwnedRepos(login string) ([]string, error) {
+	return redisClient.SMembers(userKey(login) + "::repos")
+}
+
+// isRepoOwner checks whether the logged in user has claimed the source
+// repository of the given import path
+func isRepoOwner(r *http.Request, repo string) bool {
+	login := getRequestUser(r)
+	if login == "" {
+		return false
+	}
+
+	owner, err := redisClient.SIsMember(repoOwnersKey(repo), login)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  repo,
+			"user":  login,
+		}).Error("Unable to check repository ownership")
+		return false
+	}
+
+	return owner
+}
+
+// verifyRepoOwner checks only owners of the repository are able to
+// execute owner-only actions and API tokens used for it carry the scope.
+// If the check fails the HTTP status and a message are returned, otherwise 0.
+func verifyRepoOwner(r *http.Request, repo, scope string) (int, string) {
+	if status, msg := verifyAPIScope(r, scope); status != 0 {
+		return status, msg
+	}
+
+	if isRepoOwner(r, repo) {
+		return 0, ""
+	}
+
+	return http.StatusForbidden, "Only owners of this repository are allowed to do this."
+}
+
+// checkRepoOwner ensures only owners of the repository are able to
+// execute owner-only actions and API tokens used for it carry the scope.
+// If the user is no owner a 403 is written and false is returned.
+func checkRepoOwner(res http.ResponseWriter, r *http.Request, repo, scope string) bool {
+	if status, msg := verifyRepoOwner(r, repo, scope); status != 0 {
+		http.Error(res, msg, status)
+		return false
+	}
+	return true
+}
+
+// claimRepo adds the logged in user as an owner of the source repository.
+// The user needs admin access to the source repository to claim it.
+func claimRepo(r *http.Request, repo string) error {
+	login := getGithubUsername(r)
+	if login == "" {
+		return fmt.Errorf("You need to log in to claim a repository")
+	}
+
+	if getRepoAccessLevel(r, repo) != repoAccessAdmin {
+		return fmt.Errorf("You need admin access to the source repository to claim it")
+	}
+
+	sourceRepo := credentials.SourceRepository(repo)
+	if _, err := redisClient.SAdd(repoOwnersKey(sourceRepo), login); err != nil {
+		return err
+	}
+	_, err := redisClient.SAdd(userKey(login)+"::repos", sourceRepo)
+	return err
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Luzifer/gobuilder/builddb"
+	"github.com/Luzifer/gobuilder/buildjob"
+	"github.com/Sirupsen/logrus"
+)
+
+type githubPullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+type gitlabEvent struct {
+	ObjectKind string `json:"object_kind"`
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Project    struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+	ObjectAttributes struct {
+		IID        int    `json:"iid"`
+		State      string `json:"state"`
+		Action     string `json:"action"`
+		LastCommit struct {
+			ID string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"object_attributes"`
+}
+
+func webhookGitHubPullRequest(res http.ResponseWriter, r *http.Request, data []byte) {
+	event := githubPullRequestEvent{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": fmt.Sprintf("%v", err),
+		}).Error("GitHub PullRequest Hook Error")
+		http.Error(res, "GitHub request could not be parsed.", http.StatusBadRequest)
+		return
+	}
+
+	repo := fmt.Sprintf("github.com/%s", event.Repository.FullName)
+
+	verified, ok := verifyWebhook(res, r, repo, func(secret string) bool {
+		return verifyGitHubSignature(r, data, secret)
+	})
+	if !ok {
+		return
+	}
+
+	switch event.Action {
+	case "opened", "reopened", "synchronize":
+		err := sendPullRequestToQueue(repo, event.PullRequest.Head.SHA, event.Number, fmt.Sprintf("refs/pull/%d/head", event.Number))
+		if err != nil {
+			http.Error(res, "Could not submit build job", http.StatusInternalServerError)
+			return
+		}
+	case "closed":
+		if !verified && !githubPullRequestClosed(event.Repository.FullName, event.Number) {
+			http.Error(res, "Pull request is not closed", http.StatusForbidden)
+			return
+		}
+		if err := expirePullRequestLabel(repo, event.Number); err != nil {
+			http.Error(res, "Could not expire pull request builds", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(res, "OK, got your message, will not take action.", http.StatusOK)
+		return
+	}
+
+	http.Error(res, "OK", http.StatusOK)
+}
+
+func webhookGitLab(res http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": fmt.Sprintf("%v", err),
+		}).Error("GitLab Hook Error")
+		http.Error(res, "GitLab request could not be read.", http.StatusInternalServerError)
+		return
+	}
+
+	event := gitlabEvent{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": fmt.Sprintf("%v", err),
+		}).Error("GitLab Hook Error")
+		http.Error(res, "GitLab request could not be parsed.", http.StatusBadRequest)
+		return
+	}
+
+	repo := fmt.Sprintf("%s/%s", gitlabHost(), event.Project.PathWithNamespace)
+
+	verified, ok := verifyWebhook(res, r, repo, func(secret string) bool {
+		return verifyGitLabToken(r, secret)
+	})
+	if !ok {
+		return
+	}
+
+	switch event.ObjectKind {
+	case "push":
+		if event.Ref != "refs/heads/master" {
+			http.Error(res, "OK, got your message, will not take action.", http.StatusOK)
+			return
+		}
+		err = sendToQueue(repo, event.After)
+
+	case "merge_request":
+		mr := event.ObjectAttributes
+		switch mr.State {
+		case "opened":
+			err = sendPullRequestToQueue(repo, mr.LastCommit.ID, mr.IID, fmt.Sprintf("refs/merge-requests/%d/head", mr.IID))
+		case "closed", "merged":
+			if !verified && !gitlabMergeRequestClosed(event.Project.PathWithNamespace, mr.IID) {
+				http.Error(res, "Merge request is not closed", http.StatusForbidden)
+				return
+			}
+			err = expirePullRequestLabel(repo, mr.IID)
+		default:
+			http.Error(res, "OK, got your message, will not take action.", http.StatusOK)
+			return
+		}
+
+	default:
+		http.Error(res, "OK, got your message, will not take action.", http.StatusOK)
+		return
+	}
+
+	if err != nil {
+		http.Error(res, "Could not process GitLab event", http.StatusInternalServerError)
+		return
+	}
+
+	http.Error(res, "OK", http.StatusOK)
+}
+
+func gitlabHost() string {
+	u, err := url.Parse(cfg.GitLab.URL)
+	if err != nil || u.Host == "" {
+		return "gitlab.com"
+	}
+	return u.Host
+}
+
+// githubPullRequestClosed asks GitHub whether the pull request is closed.
+// It is used to confirm unsigned webhooks before deleting anything.
+func githubPullRequestClosed(fullName string, number int) bool {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d", fullName, number), nil)
+	if cfg.GitHub.StatusToken != "" {
+		req.Header.Set("Authorization", "token "+cfg.GitHub.StatusToken)
+	}
+
+	pr := struct {
+		State string `json:"state"`
+	}{}
+	if err := fetchPullRequestState(req, &pr); err != nil {
+		log.WithFields(logrus.Fields{
+			"error":  err,
+			"repo":   fullName,
+			"number": number,
+		}).Error("Unable to confirm pull request state")
+		return false
+	}
+	return pr.State == "closed"
+}
+
+// gitlabMergeRequestClosed asks GitLab whether the merge request is closed
+// or merged. It is used to confirm unsigned webhooks before deleting anything.
+func gitlabMergeRequestClosed(project string, iid int) bool {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/v4/projects/%s/merge_requests/%d",
+		strings.TrimRight(cfg.GitLab.URL, "/"), url.QueryEscape(project), iid), nil)
+	if cfg.GitLab.StatusToken != "" {
+		req.Header.Set("PRIVATE-TOKEN", cfg.GitLab.StatusToken)
+	}
+
+	mr := struct {
+		State string `json:"state"`
+	}{}
+	if err := fetchPullRequestState(req, &mr); err != nil {
+		log.WithFields(logrus.Fields{
+			"error":  err,
+			"repo":   project,
+			"number": iid,
+		}).Error("Unable to confirm merge request state")
+		return false
+	}
+	return mr.State == "closed" || mr.State == "merged"
+}
+
+func fetchPullRequestState(req *http.Request, v interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func sendPullRequestToQueue(repository, commit string, number int, ref string) error {
+	return enqueueJob(buildjob.BuildJob{
+		Repository:         repository,
+		Commit:             commit,
+		NumberOfExecutions: 0,
+		Label:              builddb.PullRequestLabel(number),
+		Ref:                ref,
+		PullRequest:        number,
+	})
+}
+
+// expirePullRequestLabel removes everything stored for the pull request
+// label after the pull request has been merged or closed
+func expirePullRequestLabel(repository string, number int) error {
+	label := builddb.PullRequestLabel(number)
+
+	// Drop queued builds for that pull request, they would recreate the label
+	if _, err := removeQueuedJobs(func(j *buildjob.BuildJob) bool {
+		return j.Repository == repository && j.Label == label
+	}); err != nil {
+		return err
+	}
+
+	if err := deleteLabel(repository, label); err != nil {
+		return err
+	}
+
+	redisClient.Del(fmt.Sprintf("project::%s::build-status::%s", repository, label))
+
+	log.WithFields(logrus.Fields{
+		"repo":  repository,
+		"label": label,
+	}).Info("Expired pull request label")
+
+	return nil
+}
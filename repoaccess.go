@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/Luzifer/gobuilder/credentials"
+	"github.com/Sirupsen/logrus"
+)
+
+const repoAccessCacheTime = 300
+
+// This block contains the access levels a user can have to a repository
+const (
+	repoAccessNone  = "none"
+	repoAccessRead  = "read"
+	repoAccessAdmin = "admin"
+)
+
+type githubRepository struct {
+	Private     bool `json:"private"`
+	Permissions struct {
+		Admin bool `json:"admin"`
+		Push  bool `json:"push"`
+		Pull  bool `json:"pull"`
+	} `json:"permissions"`
+}
+
+// isPrivateRepo checks whether fetch credentials are registered for the
+// source repository which marks the repository as private
+func isPrivateRepo(repo string) bool {
+	exists, err := redisClient.Exists(credentials.RedisKey(repo))
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  repo,
+		}).Error("Unable to check privacy of repository")
+		// Better safe than sorry: Treat the repo as private
+		return true
+	}
+	return exists
+}
+
+// getRepoAccessLevel asks GitHub which access the logged in user has to
+// the source repository of the given import path
+func getRepoAccessLevel(r *http.Request, repo string) string {
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	token, ok := sess.Values["access_token"].(string)
+	if !ok || token == "" {
+		return repoAccessNone
+	}
+
+	sourceRepo := credentials.SourceRepository(repo)
+	matches := regexp.MustCompile("^github.com/([^/]+)/([^/]+)$").FindStringSubmatch(sourceRepo)
+	if matches == nil {
+		// We are only able to verify access to GitHub repositories
+		return repoAccessNone
+	}
+
+	cacheKey := fmt.Sprintf("access-cache::%x::%s", sha256.Sum256([]byte(token)), sourceRepo)
+	if level, err := redisClient.Get(cacheKey); err == nil && len(level) > 0 {
+		return string(level)
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("https://api.github.com/repos/%s/%s", matches[1], matches[2]), nil)
+	req.Header.Set("Authorization", "token "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  repo,
+		}).Error("Unable to fetch repository permissions")
+		return repoAccessNone
+	}
+	defer resp.Body.Close()
+
+	level := repoAccessNone
+	if resp.StatusCode == http.StatusOK {
+		ghRepo := githubRepository{}
+		if err := json.NewDecoder(resp.Body).Decode(&ghRepo); err == nil {
+			switch {
+			case ghRepo.Permissions.Admin:
+				level = repoAccessAdmin
+			case ghRepo.Permissions.Pull:
+				level = repoAccessRead
+			}
+		}
+	}
+
+	redisClient.Set(cacheKey, level, repoAccessCacheTime, 0, false, false)
+	return level
+}
+
+// verifyRepoAccess checks private repositories are only visible to users
+// having access to the source repository or owners using an API token.
+// If access is denied the HTTP status and a message are returned, otherwise 0.
+func verifyRepoAccess(r *http.Request, repo string) (int, string) {
+	if !isPrivateRepo(repo) {
+		return 0, ""
+	}
+
+	if getBearerToken(r) != "" {
+		// We can't ask GitHub for API tokens so only owners are allowed
+		return verifyRepoOwner(r, repo, apiScopeReadLogs)
+	}
+
+	if getRepoAccessLevel(r, repo) != repoAccessNone {
+		return 0, ""
+	}
+
+	return http.StatusNotFound, "Not found"
+}
+
+// checkRepoAccess ensures private repositories are only visible to users
+// having access to the source repository or owners using an API token.
+// If access is denied a 404 is written and false is returned.
+func checkRepoAccess(res http.ResponseWriter, r *http.Request, repo string) bool {
+	if status, msg := verifyRepoAccess(r, repo); status != 0 {
+		http.Error(res, msg, status)
+		return false
+	}
+	return true
+}
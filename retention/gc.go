@@ -0,0 +1,427 @@
+package retention
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"launchpad.net/goamz/s3"
+
+	"github.com/Luzifer/gobuilder/assetstore"
+	"github.com/Luzifer/gobuilder/buildconfig"
+	"github.com/Luzifer/gobuilder/builddb"
+	"github.com/xuyu/goredis"
+)
+
+// DefaultGracePeriod is the minimum age of unreferenced objects before
+// they are deleted to spare uploads of builds not yet having metadata
+const DefaultGracePeriod = 24 * time.Hour
+
+// PolicyKey returns the key the retention policy of the repository
+// configured in its .gobuilder.yml is stored at
+func PolicyKey(repo string) string {
+	return fmt.Sprintf("project::%s::retention", repo)
+}
+
+// KnownBranchesKey returns the key of the set of branches existing in the
+// source repository during the last build
+func KnownBranchesKey(repo string) string {
+	return fmt.Sprintf("project::%s::known-branches", repo)
+}
+
+// KnownTagsKey returns the key of the set of tags existing in the source
+// repository during the last build
+func KnownTagsKey(repo string) string {
+	return fmt.Sprintf("project::%s::known-tags", repo)
+}
+
+// Report lists everything removed (or to be removed in dry-run mode) from
+// a single repository
+type Report struct {
+	Repository     string
+	Skipped        string
+	Error          string
+	DeletedLabels  []string
+	DeletedBuilds  []string
+	DeletedObjects []string
+	FreedBytes     int64
+}
+
+// ObjectStore is the part of the artifact store used by the Collector,
+// it is implemented by *s3.Bucket
+type ObjectStore interface {
+	assetstore.Lister
+	Del(path string) error
+}
+
+// Collector applies the retention policies to the build DBs and removes
+// objects no longer referenced by any build from the artifact store
+type Collector struct {
+	Redis       *goredis.Redis
+	Bucket      ObjectStore
+	Policy      buildconfig.RetentionPolicy
+	GracePeriod time.Duration
+	DryRun      bool
+}
+
+// Run collects the garbage of all repositories having a build DB. A
+// repository failing to be collected does not prevent the remaining ones
+// from being collected, its error is stored in its report.
+func (c *Collector) Run() ([]*Report, error) {
+	repos := []string{}
+
+	var cursor uint64
+	for {
+		next, keys, err := c.Redis.Scan(cursor, "project::*::builddb", 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			repos = append(repos, strings.TrimSuffix(strings.TrimPrefix(k, "project::"), "::builddb"))
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	reports := []*Report{}
+	failed := 0
+	for _, repo := range repos {
+		r, err := c.CollectRepository(repo)
+		if err != nil {
+			failed++
+			r = &Report{Repository: repo, Error: err.Error()}
+		}
+		reports = append(reports, r)
+	}
+
+	if failed > 0 {
+		return reports, fmt.Errorf("%d of %d repositories could not be collected", failed, len(repos))
+	}
+	return reports, nil
+}
+
+// CollectRepository applies the retention policy to a single repository
+func (c *Collector) CollectRepository(repo string) (*Report, error) {
+	report := &Report{Repository: repo}
+
+	if locked, err := c.Redis.Exists(fmt.Sprintf("project::%s::build-lock", repo)); err != nil {
+		return nil, err
+	} else if locked {
+		report.Skipped = "build is running"
+		return report, nil
+	}
+
+	raw, err := c.Redis.Get(fmt.Sprintf("project::%s::builddb", repo))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		report.Skipped = "no build DB"
+		return report, nil
+	}
+
+	buildDB := builddb.BuildDB{}
+	if err := json.Unmarshal(raw, &buildDB); err != nil {
+		return nil, err
+	}
+
+	policy, err := c.repositoryPolicy(repo)
+	if err != nil {
+		return nil, err
+	}
+	maxAge, err := policy.MaxAgeDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.DryRun {
+		if err := builddb.Migrate(c.Redis, repo, buildDB); err != nil {
+			return nil, err
+		}
+	}
+
+	branches, err := c.memberSet(KnownBranchesKey(repo))
+	if err != nil {
+		return nil, err
+	}
+	tags, err := c.memberSet(KnownTagsKey(repo))
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := map[string]bool{
+		repo + "/build.db": true,
+	}
+
+	for label, branch := range buildDB {
+		if labelExpired(label, policy, branches, tags) {
+			report.DeletedLabels = append(report.DeletedLabels, label)
+			delete(buildDB, label)
+			if !c.DryRun {
+				if err := c.removeLabel(repo, label); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		for _, key := range labelObjects(repo, label, branch) {
+			referenced[key] = true
+		}
+
+		history, err := c.labelHistory(repo, label, branch)
+		if err != nil {
+			return nil, err
+		}
+
+		protected := policy.ShouldKeepTags() && matchesRef(label, tags)
+		for i, b := range history {
+			if buildExpired(i, b, protected, policy.KeepBuilds, maxAge, time.Now()) {
+				report.DeletedBuilds = append(report.DeletedBuilds, fmt.Sprintf("%s/%s", label, b.ID))
+				if !c.DryRun {
+					if err := builddb.RemoveBuild(c.Redis, repo, label, b.ID); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+
+			for _, a := range b.Assets {
+				if a.Path != "" {
+					referenced[a.Path] = true
+				}
+			}
+		}
+	}
+
+	if len(report.DeletedLabels) > 0 && !c.DryRun {
+		db, err := json.Marshal(buildDB)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Redis.Set(fmt.Sprintf("project::%s::builddb", repo), string(db), 0, 0, false, false); err != nil {
+			return nil, err
+		}
+	}
+
+	refs, err := assetstore.Refs(c.Redis, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	liveObjects, staleRefs := resolveRefs(repo, refs, referenced)
+	if len(staleRefs) > 0 && !c.DryRun {
+		if err := assetstore.RemoveRefs(c.Redis, repo, staleRefs...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.sweepObjects(repo, referenced, report); err != nil {
+		return nil, err
+	}
+	return report, c.sweepContentObjects(repo, liveObjects, report)
+}
+
+// labelExpired decides whether the label is removed as a whole. Labels
+// are only removed together with their branch, tags and pull requests are
+// never removed this way. Without known branches nothing is removed as
+// the branches might not have been recorded yet.
+func labelExpired(label string, policy buildconfig.RetentionPolicy, branches, tags map[string]bool) bool {
+	if matchesRef(label, tags) || builddb.IsPullRequestLabel(label) {
+		return false
+	}
+	return policy.ShouldDeleteRemovedBranches() && len(branches) > 0 && !matchesRef(label, branches)
+}
+
+// buildExpired decides whether the build at the given position of the
+// history of its label (newest first) is removed. The current build of a
+// label and all builds of protected labels are kept, the maximum age only
+// applies to former builds.
+func buildExpired(i int, b builddb.Build, protected bool, keepBuilds int, maxAge time.Duration, now time.Time) bool {
+	if i == 0 || protected {
+		return false
+	}
+	return (keepBuilds > 0 && i >= keepBuilds) || (maxAge > 0 && now.Sub(b.BuildDate) > maxAge)
+}
+
+// labelObjects returns the keys of the objects stored under the file names
+// of the current build of the label
+func labelObjects(repo, label string, branch builddb.Branch) []string {
+	keys := []string{
+		fmt.Sprintf("%s/%s_README.md", repo, strings.Replace(label, "/", "_", 1)),
+	}
+	for _, a := range branch.Assets {
+		binary := strings.TrimSuffix(a.FileName, ".zip")
+		for _, name := range []string{a.FileName, binary, binary + ".exe"} {
+			keys = append(keys, fmt.Sprintf("%s/%s", repo, name))
+		}
+	}
+	return keys
+}
+
+// resolveRefs returns the content-addressed objects still referenced by a
+// file name and the names no longer referenced by any label. An object
+// stays alive as long as one of its names is referenced. Names pointing to
+// an object are removed from referenced as the copies stored under those
+// names before the content-addressed storage are obsolete.
+func resolveRefs(repo string, refs map[string]string, referenced map[string]bool) (map[string]bool, []string) {
+	live := map[string]bool{}
+	stale := []string{}
+	for name, sha := range refs {
+		key := fmt.Sprintf("%s/%s", repo, name)
+		if !referenced[key] {
+			stale = append(stale, name)
+			continue
+		}
+		live[sha] = true
+		delete(referenced, key)
+	}
+	return live, stale
+}
+
+func (c *Collector) repositoryPolicy(repo string) (buildconfig.RetentionPolicy, error) {
+	raw, err := c.Redis.Get(PolicyKey(repo))
+	if err != nil {
+		return c.Policy, err
+	}
+	if len(raw) == 0 {
+		return c.Policy, nil
+	}
+
+	override := &buildconfig.RetentionPolicy{}
+	if err := json.Unmarshal(raw, override); err != nil {
+		return c.Policy, err
+	}
+
+	return c.Policy.Merge(override), nil
+}
+
+func (c *Collector) memberSet(key string) (map[string]bool, error) {
+	members, err := c.Redis.SMembers(key)
+	if err != nil {
+		return nil, err
+	}
+
+	set := map[string]bool{}
+	for _, m := range members {
+		set[m] = true
+	}
+	return set, nil
+}
+
+// matchesRef checks whether the label was built from one of the refs. The
+// label is derived from the asset file names in which slashes of the ref
+// were replaced by underscores and only the last part might have survived.
+func matchesRef(label string, refs map[string]bool) bool {
+	for ref := range refs {
+		normalized := strings.Replace(ref, "/", "_", -1)
+		if ref == label || normalized == label || strings.HasSuffix(normalized, "_"+label) {
+			return true
+		}
+	}
+	return false
+}
+
+// labelHistory returns the builds of the label newest first. In dry-run
+// mode labels not yet migrated are not written to the history.
+func (c *Collector) labelHistory(repo, label string, branch builddb.Branch) ([]builddb.Build, error) {
+	history, err := builddb.History(c.Redis, repo, label, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(history) == 0 {
+		history = append(history, builddb.Build{
+			ID:        builddb.LegacyBuildID,
+			Label:     label,
+			GoVersion: branch.GoVersion,
+			BuildDate: branch.BuildDate,
+			Assets:    branch.Assets,
+		})
+	}
+
+	return history, nil
+}
+
+func (c *Collector) removeLabel(repo, label string) error {
+	if err := builddb.RemoveLabel(c.Redis, repo, label); err != nil {
+		return err
+	}
+
+	_, err := c.Redis.Del(
+		fmt.Sprintf("project::%s::signatures::%s", repo, label),
+		fmt.Sprintf("project::%s::hashes::%s", repo, label),
+		fmt.Sprintf("project::%s::hashes_yml::%s", repo, label),
+	)
+	return err
+}
+
+// sweepObjects deletes all objects stored directly below the repository
+// prefix which are not referenced and older than the grace period. Objects
+// of sub-packages are stored below their own prefix and are not touched.
+func (c *Collector) sweepObjects(repo string, referenced map[string]bool, report *Report) error {
+	marker := ""
+	for {
+		list, err := c.Bucket.List(repo+"/", "/", marker, 1000)
+		if err != nil {
+			return err
+		}
+
+		for _, k := range list.Contents {
+			marker = k.Key
+			if referenced[k.Key] {
+				continue
+			}
+
+			if err := c.deleteObject(k, report); err != nil {
+				return err
+			}
+		}
+
+		if !list.IsTruncated || len(list.Contents) == 0 {
+			return nil
+		}
+	}
+}
+
+// sweepContentObjects deletes the content-addressed objects no longer
+// referenced by any file name which are older than the grace period
+func (c *Collector) sweepContentObjects(repo string, live map[string]bool, report *Report) error {
+	objects, err := assetstore.Objects(c.Bucket, repo)
+	if err != nil {
+		return err
+	}
+
+	for sha, k := range objects {
+		if live[sha] {
+			continue
+		}
+		if err := c.deleteObject(k, report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Collector) deleteObject(k s3.Key, report *Report) error {
+	gracePeriod := c.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = DefaultGracePeriod
+	}
+
+	modified, err := time.Parse(time.RFC3339, k.LastModified)
+	if err != nil || time.Since(modified) < gracePeriod {
+		return nil
+	}
+
+	report.DeletedObjects = append(report.DeletedObjects, k.Key)
+	report.FreedBytes += k.Size
+	if c.DryRun {
+		return nil
+	}
+	return c.Bucket.Del(k.Key)
+}
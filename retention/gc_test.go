@@ -0,0 +1,234 @@
+package retention
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"launchpad.net/goamz/s3"
+
+	"github.com/Luzifer/gobuilder/assetstore"
+	"github.com/Luzifer/gobuilder/buildconfig"
+	"github.com/Luzifer/gobuilder/builddb"
+)
+
+const testRepo = "github.com/Luzifer/gobuilder"
+
+// fakeStore is an in-memory ObjectStore recording all deletions
+type fakeStore struct {
+	objects map[string]s3.Key
+	deleted []string
+}
+
+func newFakeStore(objects map[string]time.Duration) *fakeStore {
+	f := &fakeStore{objects: map[string]s3.Key{}}
+	for key, age := range objects {
+		f.objects[key] = s3.Key{
+			Key:          key,
+			LastModified: time.Now().Add(-age).Format(time.RFC3339),
+			Size:         100,
+		}
+	}
+	return f
+}
+
+func (f *fakeStore) List(prefix, delim, marker string, max int) (*s3.ListResp, error) {
+	keys := []string{}
+	for key := range f.objects {
+		if !strings.HasPrefix(key, prefix) || key <= marker {
+			continue
+		}
+		if delim != "" && strings.Contains(strings.TrimPrefix(key, prefix), delim) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	resp := &s3.ListResp{}
+	if len(keys) > max {
+		keys = keys[:max]
+		resp.IsTruncated = true
+	}
+	for _, key := range keys {
+		resp.Contents = append(resp.Contents, f.objects[key])
+	}
+	return resp, nil
+}
+
+func (f *fakeStore) Del(path string) error {
+	if _, ok := f.objects[path]; !ok {
+		return fmt.Errorf("Object %q does not exist", path)
+	}
+	f.deleted = append(f.deleted, path)
+	delete(f.objects, path)
+	return nil
+}
+
+func set(members ...string) map[string]bool {
+	s := map[string]bool{}
+	for _, m := range members {
+		s[m] = true
+	}
+	return s
+}
+
+func TestLabelExpired(t *testing.T) {
+	keepBranches := false
+	policy := buildconfig.RetentionPolicy{}
+	branches := set("master", "develop", "feature/login")
+	tags := set("v1.0.0", "release/v2")
+
+	for label, expired := range map[string]bool{
+		"master":                     false,
+		"develop":                    false,
+		"login":                      false,
+		"feature_login":              false,
+		"v1.0.0":                     false,
+		"v2":                         false,
+		builddb.PullRequestLabel(12): false,
+		"removed":                    true,
+		"feature_removed":            true,
+	} {
+		if labelExpired(label, policy, branches, tags) != expired {
+			t.Errorf("labelExpired(%q) != %t", label, expired)
+		}
+	}
+
+	// Without known branches the branches might not have been recorded yet
+	if labelExpired("removed", policy, set(), tags) {
+		t.Error("Label was removed without known branches")
+	}
+
+	policy.DeleteRemovedBranches = &keepBranches
+	if labelExpired("removed", policy, branches, tags) {
+		t.Error("Label was removed with delete_removed_branches disabled")
+	}
+}
+
+func TestBuildExpired(t *testing.T) {
+	now := time.Now()
+	old := builddb.Build{BuildDate: now.Add(-48 * time.Hour)}
+	recent := builddb.Build{BuildDate: now.Add(-time.Hour)}
+
+	for _, c := range []struct {
+		name       string
+		i          int
+		build      builddb.Build
+		protected  bool
+		keepBuilds int
+		maxAge     time.Duration
+		expired    bool
+	}{
+		{"current build beyond keep_builds", 0, old, false, 1, 0, false},
+		{"current build beyond max_age", 0, old, false, 0, 24 * time.Hour, false},
+		{"former build within keep_builds", 1, recent, false, 3, 0, false},
+		{"former build beyond keep_builds", 3, recent, false, 3, 0, true},
+		{"former build within max_age", 1, recent, false, 0, 24 * time.Hour, false},
+		{"former build beyond max_age", 1, old, false, 0, 24 * time.Hour, true},
+		{"former build of protected tag", 5, old, true, 1, 24 * time.Hour, false},
+		{"former build without limits", 5, old, false, 0, 0, false},
+	} {
+		if buildExpired(c.i, c.build, c.protected, c.keepBuilds, c.maxAge, now) != c.expired {
+			t.Errorf("%s: expired != %t", c.name, c.expired)
+		}
+	}
+}
+
+func TestResolveRefs(t *testing.T) {
+	referenced := map[string]bool{}
+	for label, asset := range map[string]string{
+		"master": "gobuilder_master_linux-amd64.zip",
+		"v1.0.0": "gobuilder_v1.0.0_linux-amd64.zip",
+	} {
+		for _, key := range labelObjects(testRepo, label, builddb.Branch{
+			Assets: []builddb.Asset{{FileName: asset}},
+		}) {
+			referenced[key] = true
+		}
+	}
+
+	// The label "develop" was removed, its object is shared with master
+	// while the object of the removed label "old" has no names left
+	live, stale := resolveRefs(testRepo, map[string]string{
+		"gobuilder_master_linux-amd64.zip":  "aaaa",
+		"gobuilder_develop_linux-amd64.zip": "aaaa",
+		"gobuilder_v1.0.0_linux-amd64.zip":  "bbbb",
+		"gobuilder_old_linux-amd64.zip":     "cccc",
+	}, referenced)
+	sort.Strings(stale)
+
+	if !reflect.DeepEqual(live, set("aaaa", "bbbb")) {
+		t.Errorf("Unexpected live objects: %v", live)
+	}
+	if !reflect.DeepEqual(stale, []string{"gobuilder_develop_linux-amd64.zip", "gobuilder_old_linux-amd64.zip"}) {
+		t.Errorf("Unexpected stale refs: %v", stale)
+	}
+
+	// Names pointing to objects no longer keep their legacy copy alive
+	if referenced[testRepo+"/gobuilder_master_linux-amd64.zip"] || !referenced[testRepo+"/master_README.md"] {
+		t.Errorf("Unexpected referenced names: %v", referenced)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	old := 48 * time.Hour
+	store := newFakeStore(map[string]time.Duration{
+		testRepo + "/build.db":                                    old,
+		testRepo + "/master_README.md":                            old,
+		testRepo + "/gobuilder_master_linux-amd64.zip":            old,
+		testRepo + "/gobuilder_removed_linux-amd64.zip":           old,
+		testRepo + "/gobuilder_uploading_linux-amd64.zip":         time.Minute,
+		testRepo + "/cmd/tool/gobuilder_master_tool.zip":          old,
+		assetstore.ObjectPath(testRepo, "aaaa"):                   old,
+		assetstore.ObjectPath(testRepo, "bbbb"):                   old,
+		assetstore.ObjectPath(testRepo, "cccc"):                   time.Minute,
+		"github.com/Luzifer/other/gobuilder_master.zip":           old,
+		assetstore.ObjectPath("github.com/Luzifer/other", "dddd"): old,
+	})
+	referenced := set(
+		testRepo+"/build.db",
+		testRepo+"/master_README.md",
+		testRepo+"/gobuilder_master_linux-amd64.zip",
+	)
+	live := set("aaaa")
+
+	expected := []string{
+		assetstore.ObjectPath(testRepo, "bbbb"),
+		testRepo + "/gobuilder_removed_linux-amd64.zip",
+	}
+
+	dryRun := &Collector{Bucket: store, DryRun: true}
+	report := &Report{}
+	if err := dryRun.sweepObjects(testRepo, referenced, report); err != nil {
+		t.Fatalf("Unable to sweep objects: %s", err)
+	}
+	if err := dryRun.sweepContentObjects(testRepo, live, report); err != nil {
+		t.Fatalf("Unable to sweep content objects: %s", err)
+	}
+	sort.Strings(report.DeletedObjects)
+
+	if len(store.deleted) > 0 {
+		t.Errorf("Dry-run deleted objects: %v", store.deleted)
+	}
+	if !reflect.DeepEqual(report.DeletedObjects, expected) || report.FreedBytes != 200 {
+		t.Errorf("Unexpected dry-run report: %+v", report)
+	}
+
+	c := &Collector{Bucket: store}
+	report = &Report{}
+	if err := c.sweepObjects(testRepo, referenced, report); err != nil {
+		t.Fatalf("Unable to sweep objects: %s", err)
+	}
+	if err := c.sweepContentObjects(testRepo, live, report); err != nil {
+		t.Fatalf("Unable to sweep content objects: %s", err)
+	}
+	sort.Strings(store.deleted)
+
+	if !reflect.DeepEqual(store.deleted, expected) {
+		t.Errorf("Unexpected deleted objects: %v", store.deleted)
+	}
+}
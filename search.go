@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/gobuilder/search"
+	"github.com/Sirupsen/logrus"
+	"github.com/flosch/pongo2"
+)
+
+var searchStatuses = []string{"finished", "failed", "building", "queued"}
+
+// searchSeededKey marks the search index as filled with the repositories
+// built before the index existed
+const searchSeededKey = "search::seeded"
+
+// seedSearchIndex adds all repositories listed in the last builds to the
+// search index. This is done only once as later builds update the index.
+func seedSearchIndex() {
+	n, err := redisClient.Incr(searchSeededKey)
+	if err != nil || n > 1 {
+		return
+	}
+
+	l, err := redisClient.ZRange("last-builds", 0, -1, true)
+	if err == nil {
+		for i := 0; i+1 < len(l); i += 2 {
+			t, perr := strconv.ParseInt(l[i+1], 10, 64)
+			if perr != nil {
+				continue
+			}
+			if err = search.Add(redisClient, search.NewEntry(l[i], time.Unix(t, 0))); err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Unable to seed search index")
+		// Retry on next start
+		redisClient.Del(searchSeededKey)
+		return
+	}
+
+	log.WithFields(logrus.Fields{
+		"repos": len(l) / 2,
+	}).Info("Seeded search index from last builds")
+}
+
+// parseSearchQuery reads the search from the q, field, status and days
+// parameters of the request
+func parseSearchQuery(r *http.Request) (search.Query, error) {
+	q := search.Query{
+		Term:   strings.TrimSpace(r.FormValue("q")),
+		Field:  r.FormValue("field"),
+		Status: r.FormValue("status"),
+		Exclude: func(repo string) bool {
+			blocked, _ := blockedRepos.IsBlocked(repo)
+			return blocked || isPrivateRepo(repo)
+		},
+	}
+
+	if q.Field != "" && !search.IsField(q.Field) {
+		return q, fmt.Errorf("Parameter field must be one of %s", strings.Join(search.Fields, ", "))
+	}
+
+	if q.Status != "" && !isSearchStatus(q.Status) {
+		return q, fmt.Errorf("Parameter status must be one of %s", strings.Join(searchStatuses, ", "))
+	}
+
+	if v := r.FormValue("days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 1 {
+			return q, fmt.Errorf("Parameter days must be a positive number")
+		}
+		q.Since = time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	}
+
+	return q, nil
+}
+
+func isSearchStatus(status string) bool {
+	for _, s := range searchStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func handleSearchPage(res http.ResponseWriter, r *http.Request) {
+	template := pongo2.Must(pongo2.FromFile("frontend/search.html"))
+	ctx := getBasicContext(res, r)
+	ctx["query"] = r.FormValue("q")
+	ctx["field"] = r.FormValue("field")
+	ctx["status"] = r.FormValue("status")
+	ctx["days"] = r.FormValue("days")
+	ctx["fields"] = search.Fields
+	ctx["statuses"] = searchStatuses
+
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		ctx["error"] = err.Error()
+		res.WriteHeader(http.StatusBadRequest)
+		template.ExecuteWriter(ctx, res)
+		return
+	}
+
+	results, err := search.Search(redisClient, q)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"query": q.Term,
+		}).Error("Search failed")
+		http.Error(res, "An unknown error occured.", http.StatusInternalServerError)
+		return
+	}
+
+	start, end, pagination, err := apiV2Paginate(r, len(results))
+	if err != nil {
+		ctx["error"] = err.Error()
+		res.WriteHeader(http.StatusBadRequest)
+		template.ExecuteWriter(ctx, res)
+		return
+	}
+
+	pageURL := func(page int) string {
+		v := url.Values{}
+		for k, vals := range r.URL.Query() {
+			v[k] = vals
+		}
+		v.Set("page", strconv.Itoa(page))
+		return "/search?" + v.Encode()
+	}
+
+	ctx["results"] = results[start:end]
+	ctx["pagination"] = pagination
+	if pagination.Page > 1 {
+		ctx["prev_page"] = pageURL(pagination.Page - 1)
+	}
+	if pagination.Page < pagination.TotalPages {
+		ctx["next_page"] = pageURL(pagination.Page + 1)
+	}
+
+	template.ExecuteWriter(ctx, res)
+}
@@ -0,0 +1,251 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuyu/goredis"
+)
+
+// Fields of the repositories a search term can be matched against
+const (
+	FieldPath  = "path"
+	FieldOwner = "owner"
+	FieldName  = "name"
+)
+
+// MaxResults limits the number of repositories a single search considers
+const MaxResults = 1000
+
+const (
+	entriesKey = "search::repositories"
+	recencyKey = "search::recency"
+)
+
+// Fields lists all fields in the order they are searched
+var Fields = []string{FieldPath, FieldOwner, FieldName}
+
+// Entry describes a repository in the index
+type Entry struct {
+	Repository string    `json:"repository"`
+	Owner      string    `json:"owner"`
+	Name       string    `json:"name"`
+	LastBuild  time.Time `json:"last_build"`
+	// Status is the current build status, it is read when searching
+	Status string `json:"status"`
+}
+
+// Query describes the repositories to find
+type Query struct {
+	// Term is matched as a case insensitive prefix against the Field or
+	// all fields if no field is set. Without term all repositories match.
+	Term  string
+	Field string
+	// Status limits the results to repositories having the build status
+	Status string
+	// Since limits the results to repositories built after the time
+	Since time.Time
+	// Exclude removes repositories from the results if it returns true
+	Exclude func(repo string) bool
+}
+
+// NewEntry splits the import path of the repository into its owner and
+// name ("github.com/Luzifer/gobuilder" is owned by "Luzifer")
+func NewEntry(repo string, lastBuild time.Time) Entry {
+	parts := strings.Split(repo, "/")
+
+	e := Entry{
+		Repository: repo,
+		Name:       parts[len(parts)-1],
+		LastBuild:  lastBuild,
+	}
+	if len(parts) > 2 {
+		e.Owner = parts[1]
+	}
+	return e
+}
+
+// IsField reports whether the field can be searched
+func IsField(field string) bool {
+	for _, f := range Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func indexKey(field string) string {
+	return fmt.Sprintf("search::index::%s", field)
+}
+
+func (e Entry) fieldValue(field string) string {
+	switch field {
+	case FieldPath:
+		return e.Repository
+	case FieldOwner:
+		return e.Owner
+	case FieldName:
+		return e.Name
+	}
+	return ""
+}
+
+// indexMember builds the member of the lexicographical index: The
+// lowercased value followed by the repository it belongs to
+func indexMember(value, repo string) string {
+	return strings.ToLower(value) + "|" + repo
+}
+
+// Add stores or updates the repository in the index
+func Add(redisClient *goredis.Redis, e Entry) error {
+	for _, field := range Fields {
+		value := e.fieldValue(field)
+		if value == "" {
+			continue
+		}
+		if _, err := redisClient.ZAdd(indexKey(field), map[string]float64{
+			indexMember(value, e.Repository): 0,
+		}); err != nil {
+			return err
+		}
+	}
+
+	e.Status = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := redisClient.HSet(entriesKey, e.Repository, string(data)); err != nil {
+		return err
+	}
+
+	_, err = redisClient.ZAdd(recencyKey, map[string]float64{
+		e.Repository: float64(e.LastBuild.Unix()),
+	})
+	return err
+}
+
+// Remove deletes the repository from the index
+func Remove(redisClient *goredis.Redis, repo string) error {
+	e := NewEntry(repo, time.Time{})
+	for _, field := range Fields {
+		value := e.fieldValue(field)
+		if value == "" {
+			continue
+		}
+		if _, err := redisClient.ZRem(indexKey(field), indexMember(value, repo)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := redisClient.HDel(entriesKey, repo); err != nil {
+		return err
+	}
+
+	_, err := redisClient.ZRem(recencyKey, repo)
+	return err
+}
+
+// Search returns the repositories matching the query built last first
+func Search(redisClient *goredis.Redis, q Query) ([]Entry, error) {
+	repos, err := candidates(redisClient, q)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Entry{}
+	if len(repos) == 0 {
+		return results, nil
+	}
+
+	rawEntries, err := redisClient.HMGet(entriesKey, repos...)
+	if err != nil {
+		return nil, err
+	}
+
+	statusKeys := []string{}
+	for _, repo := range repos {
+		statusKeys = append(statusKeys, fmt.Sprintf("project::%s::build-status", repo))
+	}
+	statuses, err := redisClient.MGet(statusKeys...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, raw := range rawEntries {
+		if raw == nil {
+			continue
+		}
+
+		e := Entry{}
+		if err := json.Unmarshal(raw, &e); err != nil {
+			continue
+		}
+		e.Status = string(statuses[i])
+
+		switch {
+		case q.Exclude != nil && q.Exclude(e.Repository):
+			continue
+		case !q.Since.IsZero() && e.LastBuild.Before(q.Since):
+			continue
+		case q.Status != "" && e.Status != q.Status:
+			continue
+		}
+
+		results = append(results, e)
+	}
+
+	sort.Sort(entriesByLastBuild(results))
+	return results, nil
+}
+
+// candidates collects the repositories matching the term of the query
+func candidates(redisClient *goredis.Redis, q Query) ([]string, error) {
+	if q.Term == "" {
+		min := "-inf"
+		if !q.Since.IsZero() {
+			min = strconv.FormatInt(q.Since.Unix(), 10)
+		}
+		return redisClient.ZRevRangeByScore(recencyKey, "+inf", min, false, true, 0, MaxResults)
+	}
+
+	fields := Fields
+	if q.Field != "" {
+		fields = []string{q.Field}
+	}
+
+	term := strings.ToLower(q.Term)
+	seen := map[string]bool{}
+	repos := []string{}
+	for _, field := range fields {
+		members, err := redisClient.ZRangeByLex(indexKey(field), "["+term, "["+term+"\xff", true, 0, MaxResults)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range members {
+			parts := strings.SplitN(m, "|", 2)
+			if len(parts) != 2 || seen[parts[1]] {
+				continue
+			}
+			seen[parts[1]] = true
+			repos = append(repos, parts[1])
+		}
+	}
+
+	if len(repos) > MaxResults {
+		repos = repos[:MaxResults]
+	}
+	return repos, nil
+}
+
+type entriesByLastBuild []Entry
+
+func (e entriesByLastBuild) Len() int           { return len(e) }
+func (e entriesByLastBuild) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e entriesByLastBuild) Less(i, j int) bool { return e[i].LastBuild.After(e[j].LastBuild) }
@@ -0,0 +1,298 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Luzifer/go-openssl"
+	"github.com/xuyu/goredis"
+)
+
+// This block contains the prefixes used to detect encrypted values
+const (
+	// Prefix marks values encrypted using AES-256-GCM with a key of the
+	// repository keyring: gbenc:v2:<key id>:<base64 nonce+ciphertext>
+	Prefix = "gbenc:v2:"
+	// LegacyPrefix marks values encrypted using the OpenSSL compatible
+	// AES-CBC format with the former per-repository password
+	LegacyPrefix = "U2FsdGVkX1"
+)
+
+const currentField = "current"
+
+// Keyring holds all keys of a repository able to decrypt its secrets.
+// New secrets are always encrypted using the current key.
+type Keyring struct {
+	Current string
+	Keys    map[string][]byte
+	Legacy  string
+
+	// retired holds the IDs of the keys removed by Retire which need to
+	// be deleted when saving the keyring
+	retired       []string
+	retiredLegacy bool
+}
+
+// IsEncrypted checks whether the value is encrypted in any known format
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix) || strings.HasPrefix(value, LegacyPrefix)
+}
+
+func keyField(id string) string {
+	return "key:" + id
+}
+
+func keysRedisKey(repo string) string {
+	return fmt.Sprintf("project::%s::encryption-keys", repo)
+}
+
+func legacyRedisKey(repo string) string {
+	return fmt.Sprintf("project::%s::encryption-key", repo)
+}
+
+// LoadKeyring reads the keyring of the repository. The keyring might
+// be empty if no secrets were encrypted for the repository before.
+func LoadKeyring(redisClient *goredis.Redis, repo string) (*Keyring, error) {
+	fields, err := redisClient.HGetAll(keysRedisKey(repo))
+	if err != nil {
+		return nil, err
+	}
+
+	legacy, err := redisClient.Get(legacyRedisKey(repo))
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{
+		Current: fields[currentField],
+		Keys:    map[string][]byte{},
+		Legacy:  string(legacy),
+	}
+
+	for field, value := range fields {
+		if field == currentField {
+			continue
+		}
+		id := strings.TrimPrefix(field, "key:")
+		key, err := unwrapDataKey(repo, id, value)
+		if err != nil {
+			return nil, err
+		}
+		k.Keys[id] = key
+	}
+
+	return k, nil
+}
+
+// EnsureKeyring loads the keyring of the repository and creates the
+// first key if the repository has no current key. If another request
+// creates the first key at the same time its key is used.
+func EnsureKeyring(redisClient *goredis.Redis, repo string) (*Keyring, error) {
+	k, err := LoadKeyring(redisClient, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := k.Keys[k.Current]; ok {
+		return k, nil
+	}
+
+	if err := k.Rotate(); err != nil {
+		return nil, err
+	}
+
+	// The key is stored before it becomes the current one so the current
+	// key can always be read by others
+	wrapped, err := wrapDataKey(repo, k.Current, k.Keys[k.Current])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := redisClient.HSet(keysRedisKey(repo), keyField(k.Current), wrapped); err != nil {
+		return nil, err
+	}
+
+	created, err := redisClient.HSetnx(keysRedisKey(repo), currentField, k.Current)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		return k, nil
+	}
+
+	if _, err := redisClient.HDel(keysRedisKey(repo), keyField(k.Current)); err != nil {
+		return nil, err
+	}
+	return LoadKeyring(redisClient, repo)
+}
+
+// Save stores the keys of the keyring for the repository, switches to its
+// current key and deletes the retired keys. Keys added by others in the
+// meantime are kept.
+func (k *Keyring) Save(redisClient *goredis.Redis, repo string) error {
+	for id, key := range k.Keys {
+		wrapped, err := wrapDataKey(repo, id, key)
+		if err != nil {
+			return err
+		}
+		if _, err := redisClient.HSet(keysRedisKey(repo), keyField(id), wrapped); err != nil {
+			return err
+		}
+	}
+
+	if _, err := redisClient.HSet(keysRedisKey(repo), currentField, k.Current); err != nil {
+		return err
+	}
+
+	if len(k.retired) > 0 {
+		fields := []string{}
+		for _, id := range k.retired {
+			fields = append(fields, keyField(id))
+		}
+		if _, err := redisClient.HDel(keysRedisKey(repo), fields...); err != nil {
+			return err
+		}
+		k.retired = nil
+	}
+
+	if k.retiredLegacy {
+		if _, err := redisClient.Del(legacyRedisKey(repo)); err != nil {
+			return err
+		}
+		k.retiredLegacy = false
+	}
+	return nil
+}
+
+// Rotate creates a new key and makes it the current one. Former keys are
+// kept to decrypt existing secrets until they are retired.
+func (k *Keyring) Rotate() error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	idBuf := make([]byte, 4)
+	if _, err := rand.Read(idBuf); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(idBuf)
+
+	if k.Keys == nil {
+		k.Keys = map[string][]byte{}
+	}
+	k.Keys[id] = key
+	k.Current = id
+
+	return nil
+}
+
+// Retire removes all keys except the current one including the legacy
+// password. Secrets encrypted using those keys can't be decrypted anymore.
+func (k *Keyring) Retire() {
+	for id := range k.Keys {
+		if id != k.Current {
+			delete(k.Keys, id)
+			k.retired = append(k.retired, id)
+		}
+	}
+	if k.Legacy != "" {
+		k.Legacy = ""
+		k.retiredLegacy = true
+	}
+}
+
+// Encrypt encrypts the plain value using the current key
+func (k *Keyring) Encrypt(plain string) (string, error) {
+	key, ok := k.Keys[k.Current]
+	if !ok {
+		return "", errors.New("Keyring has no current key")
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return fmt.Sprintf("%s%s:%s", Prefix, k.Current, base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// Decrypt decrypts values encrypted using any key of the keyring or the
+// legacy password. Values not being encrypted are passed through.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, Prefix):
+		parts := strings.SplitN(strings.TrimPrefix(value, Prefix), ":", 2)
+		if len(parts) != 2 {
+			return "", errors.New("Malformed encrypted value")
+		}
+
+		key, ok := k.Keys[parts[0]]
+		if !ok {
+			return "", fmt.Errorf("Value was encrypted using unknown or retired key %q", parts[0])
+		}
+
+		sealed, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return "", err
+		}
+
+		gcm, err := newGCM(key)
+		if err != nil {
+			return "", err
+		}
+		if len(sealed) < gcm.NonceSize() {
+			return "", errors.New("Malformed encrypted value")
+		}
+
+		plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+		if err != nil {
+			return "", err
+		}
+		return string(plain), nil
+
+	case strings.HasPrefix(value, LegacyPrefix):
+		if k.Legacy == "" {
+			return "", errors.New("Value was encrypted using the retired legacy key")
+		}
+
+		plain, err := openssl.New().DecryptString(k.Legacy, value)
+		if err != nil {
+			return "", err
+		}
+		return string(plain), nil
+	}
+
+	return value, nil
+}
+
+// IsOutdated checks whether an encrypted value should be encrypted again
+// because it uses the legacy format or a key which is not current
+func (k *Keyring) IsOutdated(value string) bool {
+	if strings.HasPrefix(value, LegacyPrefix) {
+		return true
+	}
+	if strings.HasPrefix(value, Prefix) {
+		return !strings.HasPrefix(value, Prefix+k.Current+":")
+	}
+	return false
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,225 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/Luzifer/go-openssl"
+)
+
+func newTestKeyring(t *testing.T) *Keyring {
+	k := &Keyring{}
+	if err := k.Rotate(); err != nil {
+		t.Fatalf("Unable to create key: %s", err)
+	}
+	return k
+}
+
+func mustEncrypt(t *testing.T, k *Keyring, plain string) string {
+	enc, err := k.Encrypt(plain)
+	if err != nil {
+		t.Fatalf("Unable to encrypt: %s", err)
+	}
+	return enc
+}
+
+func legacyEncrypt(t *testing.T, password, plain string) string {
+	enc, err := openssl.New().EncryptString(password, plain)
+	if err != nil {
+		t.Fatalf("Unable to encrypt legacy value: %s", err)
+	}
+	return string(enc)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := newTestKeyring(t)
+
+	enc := mustEncrypt(t, k, "s3cr3t")
+	if !strings.HasPrefix(enc, Prefix+k.Current+":") || strings.Contains(enc, "s3cr3t") {
+		t.Errorf("Unexpected encrypted value %q", enc)
+	}
+	if !IsEncrypted(enc) {
+		t.Errorf("Encrypted value is not detected")
+	}
+
+	if enc2 := mustEncrypt(t, k, "s3cr3t"); enc2 == enc {
+		t.Errorf("Encrypting the same value twice gave the same result")
+	}
+
+	plain, err := k.Decrypt(enc)
+	if err != nil || plain != "s3cr3t" {
+		t.Errorf("Decrypt = %q, %v", plain, err)
+	}
+
+	if plain, err := k.Decrypt("not encrypted"); err != nil || plain != "not encrypted" {
+		t.Errorf("Plain value was not passed through: %q, %v", plain, err)
+	}
+}
+
+func TestEncryptWithoutKey(t *testing.T) {
+	if _, err := (&Keyring{}).Encrypt("s3cr3t"); err == nil {
+		t.Error("Empty keyring was able to encrypt")
+	}
+}
+
+func TestDecryptAfterRotate(t *testing.T) {
+	k := newTestKeyring(t)
+	former := k.Current
+	enc := mustEncrypt(t, k, "s3cr3t")
+
+	if err := k.Rotate(); err != nil {
+		t.Fatalf("Unable to rotate: %s", err)
+	}
+	if k.Current == former || len(k.Keys) != 2 {
+		t.Fatalf("Rotate did not add a new current key: %+v", k)
+	}
+
+	if plain, err := k.Decrypt(enc); err != nil || plain != "s3cr3t" {
+		t.Errorf("Value of former key could not be decrypted: %q, %v", plain, err)
+	}
+
+	if enc := mustEncrypt(t, k, "new"); !strings.HasPrefix(enc, Prefix+k.Current+":") {
+		t.Errorf("New value was not encrypted using the current key: %q", enc)
+	}
+}
+
+func TestDecryptAfterRetire(t *testing.T) {
+	k := newTestKeyring(t)
+	k.Legacy = "l3gacy"
+
+	formerValue := mustEncrypt(t, k, "s3cr3t")
+	legacyValue := legacyEncrypt(t, k.Legacy, "old s3cr3t")
+
+	if plain, err := k.Decrypt(legacyValue); err != nil || plain != "old s3cr3t" {
+		t.Fatalf("Legacy value could not be decrypted: %q, %v", plain, err)
+	}
+
+	if err := k.Rotate(); err != nil {
+		t.Fatalf("Unable to rotate: %s", err)
+	}
+	currentValue := mustEncrypt(t, k, "current")
+	k.Retire()
+
+	if len(k.Keys) != 1 || k.Legacy != "" {
+		t.Errorf("Retire kept former keys: %+v", k)
+	}
+
+	if _, err := k.Decrypt(formerValue); err == nil {
+		t.Error("Value of retired key could be decrypted")
+	}
+	if _, err := k.Decrypt(legacyValue); err == nil {
+		t.Error("Value of retired legacy key could be decrypted")
+	}
+	if plain, err := k.Decrypt(currentValue); err != nil || plain != "current" {
+		t.Errorf("Value of current key could not be decrypted: %q, %v", plain, err)
+	}
+}
+
+func TestDecryptMalformed(t *testing.T) {
+	k := newTestKeyring(t)
+	valid := mustEncrypt(t, k, "s3cr3t")
+	sealed := strings.SplitN(strings.TrimPrefix(valid, Prefix), ":", 2)[1]
+	raw, _ := base64.StdEncoding.DecodeString(sealed)
+	modified := append([]byte{}, raw...)
+	modified[len(modified)-1] ^= 1
+
+	for name, value := range map[string]string{
+		"missing key id":     Prefix + "abc",
+		"unknown key":        Prefix + "ffffffff:" + sealed,
+		"invalid base64":     Prefix + k.Current + ":%%%",
+		"empty ciphertext":   Prefix + k.Current + ":",
+		"short ciphertext":   Prefix + k.Current + ":" + base64.StdEncoding.EncodeToString(raw[:5]),
+		"truncated tag":      Prefix + k.Current + ":" + base64.StdEncoding.EncodeToString(raw[:len(raw)-1]),
+		"modified":           Prefix + k.Current + ":" + base64.StdEncoding.EncodeToString(modified),
+		"legacy without key": LegacyPrefix + "abc",
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("Decrypt panicked for %s: %v", name, r)
+				}
+			}()
+
+			if plain, err := k.Decrypt(value); err == nil {
+				t.Errorf("Malformed value (%s) was decrypted to %q", name, plain)
+			}
+		}()
+	}
+}
+
+func TestIsOutdated(t *testing.T) {
+	k := newTestKeyring(t)
+	k.Legacy = "l3gacy"
+
+	formerValue := mustEncrypt(t, k, "s3cr3t")
+	if err := k.Rotate(); err != nil {
+		t.Fatalf("Unable to rotate: %s", err)
+	}
+
+	for value, outdated := range map[string]bool{
+		legacyEncrypt(t, k.Legacy, "s3cr3t"): true,
+		formerValue:                          true,
+		mustEncrypt(t, k, "s3cr3t"):          false,
+		"not encrypted":                      false,
+	} {
+		if k.IsOutdated(value) != outdated {
+			t.Errorf("IsOutdated(%q) != %t", value, outdated)
+		}
+	}
+}
+
+func TestWrapDataKey(t *testing.T) {
+	former := wrapKey
+	defer func() { wrapKey = former }()
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	wrapKey = nil
+	if _, err := wrapDataKey("github.com/Luzifer/gobuilder", "abcd", key); err != ErrNoWrapKey {
+		t.Errorf("Key was wrapped without wrapping key: %v", err)
+	}
+	if err := SetWrapKey(""); err == nil {
+		t.Error("Empty wrapping key was accepted")
+	}
+
+	if err := SetWrapKey("server-secret"); err != nil {
+		t.Fatalf("Unable to set wrapping key: %s", err)
+	}
+
+	wrapped, err := wrapDataKey("github.com/Luzifer/gobuilder", "abcd", key)
+	if err != nil {
+		t.Fatalf("Unable to wrap key: %s", err)
+	}
+	if !strings.HasPrefix(wrapped, wrappedPrefix) || strings.Contains(wrapped, base64.StdEncoding.EncodeToString(key)) {
+		t.Errorf("Key is not wrapped: %q", wrapped)
+	}
+
+	if unwrapped, err := unwrapDataKey("github.com/Luzifer/gobuilder", "abcd", wrapped); err != nil || string(unwrapped) != string(key) {
+		t.Errorf("Unwrap = %q, %v", unwrapped, err)
+	}
+
+	// Keys must not be usable in other keyrings or with other IDs
+	if _, err := unwrapDataKey("github.com/Luzifer/other", "abcd", wrapped); err == nil {
+		t.Error("Key was unwrapped for another repository")
+	}
+	if _, err := unwrapDataKey("github.com/Luzifer/gobuilder", "efgh", wrapped); err == nil {
+		t.Error("Key was unwrapped for another ID")
+	}
+
+	if err := SetWrapKey("other-secret"); err != nil {
+		t.Fatalf("Unable to set wrapping key: %s", err)
+	}
+	if _, err := unwrapDataKey("github.com/Luzifer/gobuilder", "abcd", wrapped); err == nil {
+		t.Error("Key was unwrapped using another wrapping key")
+	}
+
+	// Keys stored before wrapping was introduced are plain base64
+	if unwrapped, err := unwrapDataKey("github.com/Luzifer/gobuilder", "abcd", base64.StdEncoding.EncodeToString(key)); err != nil || string(unwrapped) != string(key) {
+		t.Errorf("Plain key could not be read: %q, %v", unwrapped, err)
+	}
+
+	if _, err := unwrapDataKey("github.com/Luzifer/gobuilder", "abcd", wrappedPrefix+"c2hvcnQ="); err == nil {
+		t.Error("Short wrapped key was accepted")
+	}
+}
@@ -0,0 +1,130 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/xuyu/goredis"
+)
+
+// wrappedPrefix marks keys in Redis encrypted using the server-held
+// wrapping key: wrapped:v1:<base64 nonce+ciphertext>
+const wrappedPrefix = "wrapped:v1:"
+
+// ErrNoWrapKey is returned if keys are stored or read before the
+// wrapping key was configured using SetWrapKey
+var ErrNoWrapKey = errors.New("No keyring wrapping key configured")
+
+var wrapKey []byte
+
+// SetWrapKey configures the server-held key the keys of the keyrings are
+// encrypted with before storing them in Redis. Without it reading Redis
+// would be sufficient to decrypt all secrets.
+func SetWrapKey(key string) error {
+	if key == "" {
+		return ErrNoWrapKey
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	wrapKey = sum[:]
+	return nil
+}
+
+// wrapAAD binds the wrapped key to its repository and ID so it can't be
+// copied into another keyring
+func wrapAAD(repo, id string) []byte {
+	return []byte(repo + "\x00" + id)
+}
+
+// wrapDataKey encrypts the key of the keyring to store it in Redis
+func wrapDataKey(repo, id string, key []byte) (string, error) {
+	if wrapKey == nil {
+		return "", ErrNoWrapKey
+	}
+
+	gcm, err := newGCM(wrapKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, key, wrapAAD(repo, id))
+	return wrappedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unwrapDataKey decrypts a key stored by wrapDataKey. Keys stored before
+// wrapping was introduced are plain base64 and are read as they are.
+func unwrapDataKey(repo, id, value string) ([]byte, error) {
+	if !strings.HasPrefix(value, wrappedPrefix) {
+		return base64.StdEncoding.DecodeString(value)
+	}
+
+	if wrapKey == nil {
+		return nil, ErrNoWrapKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, wrappedPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("Malformed wrapped key")
+	}
+
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], wrapAAD(repo, id))
+}
+
+// WrapStoredKeys encrypts all keys stored in Redis before wrapping was
+// introduced using the wrapping key and returns the number of wrapped keys
+func WrapStoredKeys(redisClient *goredis.Redis) (int, error) {
+	redisKeys, err := redisClient.Keys(keysRedisKey("*"))
+	if err != nil {
+		return 0, err
+	}
+
+	wrapped := 0
+	for _, redisKey := range redisKeys {
+		repo := strings.TrimSuffix(strings.TrimPrefix(redisKey, "project::"), "::encryption-keys")
+
+		fields, err := redisClient.HGetAll(redisKey)
+		if err != nil {
+			return wrapped, err
+		}
+
+		for field, value := range fields {
+			if field == currentField || strings.HasPrefix(value, wrappedPrefix) {
+				continue
+			}
+
+			id := strings.TrimPrefix(field, keyField(""))
+			key, err := unwrapDataKey(repo, id, value)
+			if err != nil {
+				return wrapped, err
+			}
+
+			enc, err := wrapDataKey(repo, id, key)
+			if err != nil {
+				return wrapped, err
+			}
+
+			if _, err := redisClient.HSet(redisKey, field, enc); err != nil {
+				return wrapped, err
+			}
+			wrapped++
+		}
+	}
+
+	return wrapped, nil
+}
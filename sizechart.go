@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"github.com/Luzifer/gobuilder/buildconfig"
+	"github.com/Luzifer/gobuilder/builddb"
+	"github.com/Luzifer/gobuilder/downloads"
+)
+
+const (
+	sizeChartWidth  = 800
+	sizeChartHeight = 160
+	sizeChartMargin = 10
+)
+
+var sizeChartColors = []string{"#337ab7", "#5cb85c", "#f0ad4e", "#d9534f", "#5bc0de", "#8e44ad", "#7f8c8d", "#2c3e50"}
+
+// sizeChartSeries is the legend entry of a platform in the size chart
+type sizeChartSeries struct {
+	Platform string
+	Color    string
+	Current  string
+}
+
+// renderSizeChart draws the binary sizes of the main platforms across the
+// builds (newest first as returned by the history) as an SVG line chart.
+// An empty chart is returned if less than two builds know their sizes.
+func renderSizeChart(builds []builddb.Build) (string, []sizeChartSeries) {
+	// Oldest build on the left
+	points := []map[string]int64{}
+	labels := []builddb.Build{}
+	var min, max int64
+	for i := len(builds) - 1; i >= 0; i-- {
+		sizes := map[string]int64{}
+		for _, a := range builds[i].Assets {
+			platform := downloads.Platform(a.FileName)
+			if a.BinarySize == 0 || !isMainPlatform(platform) {
+				continue
+			}
+			sizes[platform] = a.BinarySize
+			if a.BinarySize > max {
+				max = a.BinarySize
+			}
+			if min == 0 || a.BinarySize < min {
+				min = a.BinarySize
+			}
+		}
+		if len(sizes) > 0 {
+			points = append(points, sizes)
+			labels = append(labels, builds[i])
+		}
+	}
+
+	if len(points) < 2 {
+		return "", nil
+	}
+
+	platforms := []string{}
+	for platform := range points[len(points)-1] {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+
+	// Leave some room below the smallest binary to make changes visible
+	// without exaggerating them
+	lower := float64(min) * 0.9
+	step := float64(sizeChartWidth-2*sizeChartMargin) / float64(len(points)-1)
+	x := func(i int) float64 { return sizeChartMargin + float64(i)*step }
+	y := func(size int64) float64 {
+		return sizeChartMargin + float64(sizeChartHeight-2*sizeChartMargin)*(1-(float64(size)-lower)/(float64(max)-lower))
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" class="size-chart">`, sizeChartWidth, sizeChartHeight)
+
+	series := []sizeChartSeries{}
+	for n, platform := range platforms {
+		color := sizeChartColors[n%len(sizeChartColors)]
+		series = append(series, sizeChartSeries{
+			Platform: platform,
+			Color:    color,
+			Current:  buildconfig.FormatSize(points[len(points)-1][platform]),
+		})
+
+		line := new(bytes.Buffer)
+		dots := new(bytes.Buffer)
+		for i, sizes := range points {
+			size, ok := sizes[platform]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(line, "%.1f,%.1f ", x(i), y(size))
+
+			title := fmt.Sprintf("%s: %s (%s)", platform, buildconfig.FormatSize(size), labels[i].BuildDate.Format("2006-01-02 15:04"))
+			if labels[i].Commit != "" {
+				title += " " + labels[i].Commit
+			}
+			fmt.Fprintf(dots, `<circle cx="%.1f" cy="%.1f" r="4" fill="%s"><title>%s</title></circle>`, x(i), y(size), color, html.EscapeString(title))
+		}
+
+		fmt.Fprintf(buf, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2" />`, line.String(), color)
+		buf.Write(dots.Bytes())
+	}
+
+	buf.WriteString("</svg>")
+	return buf.String(), series
+}
+
+// isMainPlatform reports whether the platform is one of the platforms
+// listed by default on the repository page
+func isMainPlatform(platform string) bool {
+	for _, prefix := range []string{"linux-", "darwin-", "windows-"} {
+		if strings.HasPrefix(platform, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -15,7 +15,8 @@ func getBasicContext(res http.ResponseWriter, r *http.Request) pongo2.Context {
 	sess, _ := sessionStore.Get(r, "GoBuilderSession")
 
 	ctx := pongo2.Context{
-		"gh_user": getGithubUsername(r),
+		"gh_user":    getGithubUsername(r),
+		"csrf_token": csrfToken(sess),
 	}
 
 	if errorMessages := sess.Flashes("alert_error"); len(errorMessages) > 0 {
@@ -51,6 +52,10 @@ func getNewBuildContext(res http.ResponseWriter, r *http.Request) pongo2.Context
 	ctx["lastBuilds"] = lastBuilds
 	ctx["activeWorkers"] = activeWorkers
 
+	if ctx["gh_user"] != "" {
+		ctx["dashboard"] = getDashboard(r)
+	}
+
 	return ctx
 }
 
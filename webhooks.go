@@ -23,6 +23,11 @@ func webhookGitHub(res http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Header.Get("X-GitHub-Event") == "pull_request" {
+		webhookGitHubPullRequest(res, r, data)
+		return
+	}
+
 	var tmp interface{}
 	json.Unmarshal([]byte(data), &tmp)
 	repoName := tmp.(map[string]interface{})["repository"].(map[string]interface{})["full_name"].(string)
@@ -107,6 +112,10 @@ func webhookInterface(res http.ResponseWriter, r *http.Request) {
 }
 
 func webhookCLI(res http.ResponseWriter, r *http.Request) {
+	if !checkAPIScope(res, r, apiScopeBuild) {
+		return
+	}
+
 	repo, commit := parseRepoCommit(r.FormValue("repository"))
 
 	// No repository was given, just submitted
@@ -140,15 +149,18 @@ func isValidRepositorySource(repository string) bool {
 }
 
 func sendToQueue(repository, commit string) error {
-	if blocked, reason := blockedRepos.IsBlocked(repository); blocked {
-		return errors.New("This repository is blocked: " + reason)
-	}
-
-	job := buildjob.BuildJob{
+	return enqueueJob(buildjob.BuildJob{
 		Repository:         repository,
 		Commit:             commit,
 		NumberOfExecutions: 0,
+	})
+}
+
+func enqueueJob(job buildjob.BuildJob) error {
+	if blocked, reason := blockedRepos.IsBlocked(job.Repository); blocked {
+		return errors.New("This repository is blocked: " + reason)
 	}
+
 	queueEntry, err := job.ToByte()
 	if err != nil {
 		log.Error(fmt.Sprintf("%q", err))
@@ -170,7 +182,7 @@ func sendToQueue(repository, commit string) error {
 		if err != nil {
 			return err
 		}
-		if j.Repository == job.Repository && j.Commit == job.Commit {
+		if j.Repository == job.Repository && j.Commit == job.Commit && j.Label == job.Label && j.Force == job.Force {
 			// We have a matching entry in the queue, will not add!
 			return nil
 		}
@@ -179,7 +191,11 @@ func sendToQueue(repository, commit string) error {
 	// Put the job into the queue and give it a time to run of 900 secs
 	redisClient.RPush("build-queue", string(queueEntry))
 
-	err = redisClient.Set(fmt.Sprintf("project::%s::build-status", repository), "queued", 0, 0, false, false)
+	statusKey := fmt.Sprintf("project::%s::build-status", job.Repository)
+	if job.IsIsolated() {
+		statusKey = fmt.Sprintf("%s::%s", statusKey, job.Label)
+	}
+	err = redisClient.Set(statusKey, "queued", 0, 0, false, false)
 	if err != nil {
 		fmt.Printf("%+v", err)
 	}
@@ -187,6 +203,32 @@ func sendToQueue(repository, commit string) error {
 	return nil
 }
 
+// removeQueuedJobs drops all jobs from the build queue matched by the
+// given function and returns the number of removed jobs
+func removeQueuedJobs(match func(*buildjob.BuildJob) bool) (int, error) {
+	queueItems, err := redisClient.LRange("build-queue", 0, -1)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, item := range queueItems {
+		j, err := buildjob.FromBytes([]byte(item))
+		if err != nil {
+			continue
+		}
+		if match(j) {
+			n, err := redisClient.LRem("build-queue", 0, item)
+			if err != nil {
+				return removed, err
+			}
+			removed += int(n)
+		}
+	}
+
+	return removed, nil
+}
+
 func parseRepoCommit(repo string) (string, string) {
 	t := strings.Split(repo, "@")
 	if len(t) == 1 {
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/Luzifer/gobuilder/credentials"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
+)
+
+func webhookSecretKey(repo string) string {
+	return fmt.Sprintf("project::%s::webhook-secret", credentials.SourceRepository(repo))
+}
+
+// getWebhookSecret returns the secret webhooks of the repository are
+// signed with or an empty string if the owners did not create one
+func getWebhookSecret(repo string) (string, error) {
+	secret, err := redisClient.Get(webhookSecretKey(repo))
+	return string(secret), err
+}
+
+// ensureWebhookSecret returns the webhook secret of the repository and
+// creates it if the repository has none
+func ensureWebhookSecret(repo string) (string, error) {
+	if secret, err := getWebhookSecret(repo); err != nil || secret != "" {
+		return secret, err
+	}
+
+	// If another request created the secret in the meantime it is kept
+	secret := hex.EncodeToString(securecookie.GenerateRandomKey(32))
+	redisClient.Set(webhookSecretKey(repo), secret, 0, 0, false, true)
+	return getWebhookSecret(repo)
+}
+
+// verifyGitHubSignature checks the X-Hub-Signature-256 header contains the
+// HMAC-SHA256 of the body using the secret
+func verifyGitHubSignature(r *http.Request, body []byte, secret string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Hub-Signature-256")))
+}
+
+// verifyGitLabToken checks the X-Gitlab-Token header matches the secret
+func verifyGitLabToken(r *http.Request, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(r.Header.Get("X-Gitlab-Token"))) == 1
+}
+
+// verifyWebhook checks the webhook request for the repository was sent by
+// the source host if the repository has a webhook secret. It returns
+// whether the request was verified and writes a 403 and returns false in
+// ok if the verification failed.
+func verifyWebhook(res http.ResponseWriter, r *http.Request, repo string, verify func(secret string) bool) (verified, ok bool) {
+	secret, err := getWebhookSecret(repo)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  repo,
+		}).Error("Unable to read webhook secret")
+		http.Error(res, "An unknown error occured.", http.StatusInternalServerError)
+		return false, false
+	}
+
+	if secret == "" {
+		return false, true
+	}
+
+	if !verify(secret) {
+		log.WithFields(logrus.Fields{
+			"repo": repo,
+		}).Warn("Rejected webhook with invalid signature")
+		http.Error(res, "Invalid webhook signature", http.StatusForbidden)
+		return false, false
+	}
+
+	return true, true
+}
+
+func apiV1HandlerWebhookSecret(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sess, _ := sessionStore.Get(r, "GoBuilderSession")
+	if !checkRepoOwner(res, r, vars["repo"], apiScopeAdmin) || !checkCSRF(res, r) {
+		return
+	}
+
+	secret, err := ensureWebhookSecret(vars["repo"])
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"repo":  vars["repo"],
+		}).Error("Unable to create webhook secret")
+		http.Error(res, "Could not create webhook secret", http.StatusInternalServerError)
+		return
+	}
+
+	sess.AddFlash(fmt.Sprintf("The webhook secret of this repository is %s - configure it as the secret (GitHub) or secret token (GitLab) of your webhook.", secret), "alert_success")
+	sess.Save(r, res)
+	http.Redirect(res, r, fmt.Sprintf("/%s", vars["repo"]), http.StatusFound)
+}